@@ -0,0 +1,72 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "math"
+
+const (
+	// anomalyWindow is the number of recent collections whose
+	// removal counts are averaged by the anomaly check.
+	anomalyWindow = 10
+
+	// anomalyMinRuns is the number of collections needed
+	// before the anomaly check starts working.
+	anomalyMinRuns = 3
+)
+
+// AnomalyCheck enables the anomaly check and returns the same GC.
+//
+// Before removing sessions, the collector compares the number of expired
+// sessions it found with the average number of sessions removed by recent
+// collections. If it is more than factor times greater, for example,
+// because the clock jumped or the configuration changed by mistake,
+// the collector calls confirm with the average and the number of
+// expired sessions. Unless confirm returns true, the collection doesn't
+// remove anything and its report has Anomaly set to true.
+//
+// If confirm is nil, such collections don't remove anything.
+// If factor is zero, the check is disabled. The check is not performed
+// in strict memory mode.
+//
+// The function confirm is called in the middle of the collection,
+// so it must not call methods of GC.
+func (gc *GC) AnomalyCheck(factor float64, confirm func(average float64, expired int) bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.anomalyFactor = factor
+	gc.anomalyConfirm = confirm
+	return gc
+}
+
+// isAnomaly reports whether removing the given number of expired
+// sessions is an anomaly that wasn't confirmed.
+func (gc *GC) isAnomaly(expired int) bool {
+	if gc.anomalyFactor <= 0 || len(gc.recentRemoved) < anomalyMinRuns {
+		return false
+	}
+	sum := 0
+	for _, n := range gc.recentRemoved {
+		sum += n
+	}
+	avg := float64(sum) / float64(len(gc.recentRemoved))
+	if float64(expired) <= gc.anomalyFactor*math.Max(avg, 1) {
+		return false
+	}
+	return gc.anomalyConfirm == nil || !gc.anomalyConfirm(avg, expired)
+}
+
+// recordRemoved records the number of sessions removed by the collection
+// for the anomaly check.
+func (gc *GC) recordRemoved(n int) {
+	if len(gc.recentRemoved) == anomalyWindow {
+		copy(gc.recentRemoved, gc.recentRemoved[1:])
+		gc.recentRemoved = gc.recentRemoved[:anomalyWindow-1]
+	}
+	gc.recentRemoved = append(gc.recentRemoved, n)
+}
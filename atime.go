@@ -0,0 +1,61 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"time"
+)
+
+// ExpireByAccess enables or disables expiring sessions by their last
+// access time instead of modification time, and returns the same GC.
+//
+// Sessions that are read on every request, but rarely saved, are then
+// kept while they are in use. If the session directory is on a filesystem
+// mounted with noatime, or access times are not available on the system,
+// the collector falls back to modification times. Note that with the
+// relatime mount option, which is the default on Linux, access times are
+// updated at most once a day, unless the file is modified, so idle
+// sessions may be kept up to a day longer than the max age.
+//
+// The collector reads session files for SessionMaxAge without updating
+// their access times where the system allows it: on Linux, if the files
+// are owned by the user of the process. Functions set by RetainDaily that
+// read session files should do the same, for example, by opening them
+// with the syscall.O_NOATIME flag, otherwise reads by the collector keep
+// idle sessions alive.
+//
+// Lag reports and quota ordering still use modification times.
+func (gc *GC) ExpireByAccess(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.byAccess = enable
+	gc.detectNoatime()
+	return gc
+}
+
+// detectNoatime checks whether the session directory is on a filesystem
+// that doesn't update access times.
+func (gc *GC) detectNoatime() {
+	gc.noatime = gc.byAccess && noatimeFS(gc.dir)
+}
+
+// lastUsed returns the time the session file was last used: its access
+// time in access time mode, unless it's unavailable or earlier than
+// the modification time, or the modification time otherwise.
+func (gc *GC) lastUsed(fi os.FileInfo) time.Time {
+	mtime := fi.ModTime()
+	if !gc.byAccess || gc.noatime {
+		return mtime
+	}
+	if atime, ok := accessTime(fi); ok && atime.After(mtime) {
+		return atime
+	}
+	return mtime
+}
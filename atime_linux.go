@@ -0,0 +1,46 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// stNoatime is the ST_NOATIME mount flag.
+const stNoatime = 0x400
+
+// accessTime returns the access time of the file.
+func accessTime(fi os.FileInfo) (time.Time, bool) {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec)), true
+}
+
+// openNoatime opens the file for reading without updating its access
+// time, unless the process is not allowed to do so, in which case the
+// file is opened as usual.
+func openNoatime(name string) (*os.File, error) {
+	f, err := os.OpenFile(name, os.O_RDONLY|syscall.O_NOATIME, 0)
+	if os.IsPermission(err) {
+		return os.Open(name)
+	}
+	return f, err
+}
+
+// noatimeFS reports whether dir is on a filesystem mounted with noatime.
+func noatimeFS(dir string) bool {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false
+	}
+	return st.Flags&stNoatime != 0
+}
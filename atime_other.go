@@ -0,0 +1,33 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+//go:build !linux
+// +build !linux
+
+package fsgc
+
+import (
+	"os"
+	"time"
+)
+
+// accessTime returns the access time of the file. Access times are only
+// supported on Linux.
+func accessTime(fi os.FileInfo) (time.Time, bool) {
+	return time.Time{}, false
+}
+
+// openNoatime opens the file for reading. Opening files without updating
+// their access times is only supported on Linux.
+func openNoatime(name string) (*os.File, error) {
+	return os.Open(name)
+}
+
+// noatimeFS reports whether dir is on a filesystem mounted with noatime.
+func noatimeFS(dir string) bool {
+	return false
+}
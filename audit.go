@@ -0,0 +1,58 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"strconv"
+)
+
+// AuditIssue describes a problem with a session file found by Audit.
+type AuditIssue struct {
+	Session SessionInfo
+	Problem string // description of the problem
+}
+
+// Audit checks permissions of session files, including files in cold
+// storage, and returns issues found: files accessible by group or others,
+// and, on Linux, files owned by a user other than the one running the
+// process. It doesn't remove anything.
+//
+// Session files may contain sensitive data, so in a hardened deployment
+// Audit should return no issues.
+func (gc *GC) Audit() ([]AuditIssue, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	dirs, err := gc.sessionDirs()
+	if err != nil {
+		return nil, err
+	}
+	now := gc.now()
+	uid := os.Geteuid()
+	var issues []AuditIssue
+	for _, d := range dirs {
+		fis, err := gc.readSessions(d.path)
+		if err != nil {
+			if d.cold && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		p := gc.policyFor(d.tenant)
+		for _, fi := range fis {
+			info := newSessionInfo(d.tenant, d.path, fi, gc.isExpired(p, fi, now))
+			if perm := fi.Mode().Perm(); perm&0077 != 0 {
+				issues = append(issues, AuditIssue{info, "accessible by group or others: " + perm.String()})
+			}
+			if owner, ok := fileOwner(fi); ok && owner != uid {
+				issues = append(issues, AuditIssue{info, "owned by unexpected user " + strconv.Itoa(owner)})
+			}
+		}
+	}
+	return issues, nil
+}
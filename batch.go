@@ -0,0 +1,70 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// SyncBatch sets the number of session file removals after which the
+// collector syncs the directories they were removed from, and returns
+// the same GC. Directories are also synced after the collector finishes
+// removing sessions from them. If n is zero, which is the default,
+// directories are not synced.
+//
+// Syncing once per batch rather than leaving it to the filesystem makes
+// removals durable in predictable chunks, and on journaling filesystems,
+// such as ext4 and XFS, groups metadata updates of many removals into
+// fewer journal commits.
+func (gc *GC) SyncBatch(n int) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.syncBatch = n
+	return gc
+}
+
+// batchRemoval records removal of the session file
+// and syncs directories if the batch is full.
+func (gc *GC) batchRemoval(path string) {
+	if gc.syncBatch <= 0 {
+		return
+	}
+	dir := filepath.Dir(path)
+	if memory, _ := gc.memoryFSOf(dir); memory {
+		return
+	}
+	if gc.syncDirs == nil {
+		gc.syncDirs = make(map[string]bool)
+	}
+	gc.syncDirs[dir] = true
+	gc.syncPending++
+	if gc.syncPending >= gc.syncBatch {
+		gc.flushBatch()
+	}
+}
+
+// flushBatch syncs directories with pending removals.
+func (gc *GC) flushBatch() {
+	for dir := range gc.syncDirs {
+		syncDir(dir) // ignore errors
+		delete(gc.syncDirs, dir)
+	}
+	gc.syncPending = 0
+}
+
+// syncDir syncs the directory to persist changes of its entries.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,35 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "time"
+
+// catchUpCheck is the period of checking whether the wall clock jumped
+// forward, for example, because the host was suspended.
+const catchUpCheck = time.Minute
+
+// wallGap returns how much more than the period passed between the given
+// wall clock times in Unix nanoseconds.
+//
+// Tickers use the monotonic clock, which on some systems stops while the
+// host is suspended, so after waking up, a started collector would wait
+// for the rest of the interval even though the sessions expired long ago.
+// Comparing wall clock times detects such gaps, as well as clock jumps.
+func wallGap(prev, now int64, period time.Duration) time.Duration {
+	return time.Duration(now-prev) - period
+}
+
+// logCatchUp logs the catch-up collection after the gap.
+func (gc *GC) logCatchUp(gap time.Duration) {
+	gc.mu.Lock()
+	logger := gc.logger
+	gc.mu.Unlock()
+	if logger != nil {
+		logger.Printf("fsgc: clock jumped forward by %s, collecting now", gap)
+	}
+}
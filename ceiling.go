@@ -0,0 +1,54 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "time"
+
+// EnforceCeiling sets the maximum age of sessions that can't be exceeded
+// by any configuration, and returns the same GC. Max ages set by MaxAge
+// and TenantPolicy above the ceiling are treated as the ceiling, and
+// sessions older than it are removed even if they are pinned or have
+// a later expiration time (see Xattrs), or if the functions set by
+// ExpireFunc or SessionMaxAge say otherwise. Zero disables the ceiling.
+//
+// It allows enforcing organization-wide limits, such as "sessions never
+// live more than 30 days", regardless of how individual services
+// configure the collector. Collections report the clamp in
+// Report.Clamped and log it.
+func (gc *GC) EnforceCeiling(max time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.ceiling = max
+	gc.rescheduleLocked()
+	return gc
+}
+
+// clampMaxAge returns the max age limited by the ceiling.
+func (gc *GC) clampMaxAge(d time.Duration) time.Duration {
+	if gc.ceiling > 0 && d > gc.ceiling {
+		return gc.ceiling
+	}
+	return d
+}
+
+// isClamped reports whether any configured max age exceeds the ceiling.
+func (gc *GC) isClamped() bool {
+	if gc.ceiling <= 0 {
+		return false
+	}
+	if gc.maxAge > gc.ceiling {
+		return true
+	}
+	for _, p := range gc.policies {
+		if p.MaxAge > gc.ceiling {
+			return true
+		}
+	}
+	return false
+}
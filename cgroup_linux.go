@@ -0,0 +1,42 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// cgroupWriteIOPS returns the write IOPS limit of the cgroup v2 of this
+// process and its ancestors for the disk containing dir.
+func cgroupWriteIOPS(dir string) (int64, bool) {
+	var st syscall.Stat_t
+	if err := syscall.Stat(dir, &st); err != nil {
+		return 0, false
+	}
+	dev := uint64(st.Dev)
+	major := (dev>>8)&0xfff | (dev>>32)&^0xfff
+	minor := dev&0xff | (dev>>12)&^0xff
+	major, minor = wholeDisk("/sys/dev/block", major, minor)
+	f, err := os.Open("/proc/self/cgroup")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		// cgroup v2 entry has the form "0::/path".
+		if !strings.HasPrefix(s.Text(), "0::") {
+			continue
+		}
+		return cgroupLimit("/sys/fs/cgroup", strings.TrimPrefix(s.Text(), "0::"), major, minor)
+	}
+	return 0, false
+}
@@ -0,0 +1,17 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+//go:build !linux
+// +build !linux
+
+package fsgc
+
+// cgroupWriteIOPS returns the write IOPS limit of the cgroup v2 of this
+// process and its ancestors for the disk containing dir.
+func cgroupWriteIOPS(dir string) (int64, bool) {
+	return 0, false
+}
@@ -0,0 +1,65 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "time"
+
+// Clock provides the current time and tickers to the collector.
+// See WithClock.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers ticks of a clock, like time.Ticker.
+type Ticker interface {
+	C() <-chan time.Time // channel receiving ticks
+	Stop()               // stops the ticker
+}
+
+// realClock is the clock using the time package.
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return realTicker{time.NewTicker(d)}
+}
+
+// realTicker wraps time.Ticker to implement Ticker.
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// WithClock sets the clock used for deciding whether sessions are expired
+// and for scheduling collections, and returns the same GC. If c is nil,
+// the real clock is used.
+//
+// A fake clock allows testing code that depends on expiry of sessions
+// deterministically: ticks of the fake ticker trigger collections, and
+// the fake current time decides what is expired, without waiting or
+// changing file modification times. Timers used by Precise, Jitter, and
+// CollectOnStart, as well as rate limits and budgets, still use the real
+// clock.
+//
+// WithClock replaces the function set by WithNowFunc. It doesn't affect
+// a started collector until it is restarted.
+func (gc *GC) WithClock(c Clock) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	if c == nil {
+		c = realClock{}
+	}
+	gc.clock = c
+	gc.now = c.Now
+	return gc
+}
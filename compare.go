@@ -0,0 +1,84 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// Compare returns information about sessions that would be removed
+// by a collection with policy a but not with policy b, and vice versa.
+// It doesn't remove anything.
+//
+// The policies are applied to all session directories, including all
+// tenant directories in multi-tenant mode. Zero MaxAge in a policy means
+// the max age of the collector.
+//
+// Compare allows evaluating the impact of changing the policy, such as
+// tightening max age, before applying it.
+func (gc *GC) Compare(a, b Policy) (onlyA, onlyB []SessionInfo, err error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if a.MaxAge == 0 {
+		a.MaxAge = gc.maxAge
+	}
+	if b.MaxAge == 0 {
+		b.MaxAge = gc.maxAge
+	}
+	a.MaxAge = gc.clampMaxAge(a.MaxAge)
+	b.MaxAge = gc.clampMaxAge(b.MaxAge)
+	dirs, err := gc.sessionDirs()
+	if err != nil {
+		return nil, nil, err
+	}
+	// Group files by tenant, since quotas apply per tenant.
+	var tenants []string
+	files := make(map[string][]sessionFile)
+	for _, d := range dirs {
+		fis, err := gc.readSessions(d.path)
+		if err != nil {
+			if d.cold && os.IsNotExist(err) {
+				continue
+			}
+			return nil, nil, err
+		}
+		if _, ok := files[d.tenant]; !ok {
+			tenants = append(tenants, d.tenant)
+		}
+		for _, fi := range fis {
+			files[d.tenant] = append(files[d.tenant], sessionFile{tenant: d.tenant, path: filepath.Join(d.path, fi.Name()), fi: fi})
+		}
+	}
+	now := gc.now()
+	for _, t := range tenants {
+		va := gc.victims(files[t], a, now)
+		vb := gc.victims(files[t], b, now)
+		onlyA = append(onlyA, difference(t, va, vb)...)
+		onlyB = append(onlyB, difference(t, vb, va)...)
+	}
+	return onlyA, onlyB, nil
+}
+
+// difference returns information about session files that are in x,
+// but not in y.
+func difference(tenant string, x, y []sessionFile) []SessionInfo {
+	inY := make(map[string]bool, len(y))
+	for _, f := range y {
+		inY[f.path] = true
+	}
+	var sessions []SessionInfo
+	for _, f := range x {
+		if !inY[f.path] {
+			info := newSessionInfo(tenant, filepath.Dir(f.path), f.fi, true)
+			info.Reason = f.reason
+			sessions = append(sessions, info)
+		}
+	}
+	return sessions
+}
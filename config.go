@@ -0,0 +1,203 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"time"
+)
+
+// Config describes the configuration of a collector.
+type Config struct {
+	Dir            string            // session directory
+	ExtraDirs      []string          // additional session directories
+	MaxAge         time.Duration     // max age of sessions
+	Interval       time.Duration     // interval between collections
+	AutoInterval   bool              // derive interval from max age
+	Precise        bool              // precise expiry scheduling
+	ColdDir        string            // cold storage directory
+	ColdAfter      time.Duration     // age of sessions moved to cold storage
+	Tenants        bool              // multi-tenant mode
+	Policies       map[string]Policy // per-tenant policies
+	SkipExtensions []string          // extensions of files to skip
+	Order          DeleteOrder       // order of removal
+	SkipOpenFiles  bool              // skip files open by this process
+	StateFile      string            // path to state file
+	MatchGlob      string            // pattern of session file names
+	MatchRegexp    string            // regular expression of session file names
+	Hooks          Hooks             // functions called by the collector
+}
+
+// Config returns the effective configuration of the collector.
+// It waits for the in-progress collection to finish.
+func (gc *GC) Config() Config {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.configLocked()
+}
+
+// configLocked returns the effective configuration of the collector.
+// It must be called with the lock held.
+func (gc *GC) configLocked() Config {
+	policies := make(map[string]Policy, len(gc.policies))
+	for t, p := range gc.policies {
+		policies[t] = p
+	}
+	return Config{
+		Dir:            gc.dir,
+		ExtraDirs:      append([]string(nil), gc.extraDirs...),
+		MaxAge:         gc.maxAge,
+		Interval:       gc.effectiveInterval(),
+		AutoInterval:   gc.auto,
+		Precise:        gc.precise,
+		ColdDir:        gc.coldDir,
+		ColdAfter:      gc.coldAfter,
+		Tenants:        gc.tenants,
+		Policies:       policies,
+		SkipExtensions: append([]string(nil), gc.skipExts...),
+		Order:          gc.order,
+		SkipOpenFiles:  gc.skipOpen,
+		StateFile:      gc.stateFile,
+		MatchGlob:      gc.matchGlob,
+		MatchRegexp:    gc.matchRegexp,
+		Hooks: Hooks{
+			OnRunStart: gc.onRunStart,
+			OnRunEnd:   gc.onRunEnd,
+			OnError:    gc.onError,
+			OnDelete:   gc.onDelete,
+		},
+	}
+}
+
+// Validate checks that the configuration is sane: that ages and intervals
+// are positive, and that directories exist and allow removing files.
+// It returns the first problem found.
+//
+// To check that the directories allow removing files, Validate creates
+// and removes a temporary file in them.
+func (c *Config) Validate() error {
+	if c.MaxAge <= 0 {
+		return errors.New("fsgc: max age must be positive")
+	}
+	if c.Interval <= 0 && !c.AutoInterval {
+		return errors.New("fsgc: interval must be positive")
+	}
+	if err := checkDir(c.Dir); err != nil {
+		return err
+	}
+	for _, d := range c.ExtraDirs {
+		if err := checkDir(d); err != nil {
+			return err
+		}
+	}
+	if c.ColdDir != "" {
+		if c.ColdAfter <= 0 || c.ColdAfter >= c.MaxAge {
+			return errors.New("fsgc: cold storage age must be positive and less than max age")
+		}
+		if err := checkDir(c.ColdDir); err != nil {
+			return err
+		}
+	}
+	if _, err := filepath.Match(c.MatchGlob, ""); err != nil {
+		return fmt.Errorf("fsgc: invalid glob pattern %q: %v", c.MatchGlob, err)
+	}
+	if _, err := regexp.Compile(c.MatchRegexp); err != nil {
+		return err
+	}
+	for t, p := range c.Policies {
+		if p.MaxAge < 0 || p.MaxSessions < 0 || p.MaxBytes < 0 {
+			return fmt.Errorf("fsgc: negative limit in policy for tenant %q", t)
+		}
+	}
+	return nil
+}
+
+// EnsureDir creates the session directory with the given permissions,
+// such as 0700, along with any missing parents, and verifies that files
+// can be created in it and removed from it by creating and removing
+// a temporary file. If the directory already exists, its permissions
+// are not changed.
+//
+// It is useful when setting up both the store and the collector:
+//
+//   if err := fsgc.EnsureDir(path, 0700); err != nil {
+//           log.Fatal(err)
+//   }
+//   store := sessions.NewFilesystemStore(path, []byte("secret"))
+//   gc := fsgc.New(path).Start()
+func EnsureDir(path string, perm os.FileMode) error {
+	if err := os.MkdirAll(path, perm); err != nil {
+		return err
+	}
+	return checkDir(path)
+}
+
+// checkDir checks that dir is a directory which allows removing files.
+func checkDir(dir string) error {
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("fsgc: %s is not a directory", dir)
+	}
+	f, err := ioutil.TempFile(dir, ".fsgc-probe")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// String returns a human-readable dump of the configuration.
+func (c Config) String() string {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "dir: %s\n", c.Dir)
+	for _, d := range c.ExtraDirs {
+		fmt.Fprintf(&b, "extra dir: %s\n", d)
+	}
+	fmt.Fprintf(&b, "max age: %s\n", c.MaxAge)
+	fmt.Fprintf(&b, "interval: %s", c.Interval)
+	if c.AutoInterval {
+		fmt.Fprintf(&b, " (auto)")
+	}
+	fmt.Fprintf(&b, "\nprecise: %v\n", c.Precise)
+	if c.ColdDir != "" {
+		fmt.Fprintf(&b, "cold dir: %s (after %s)\n", c.ColdDir, c.ColdAfter)
+	}
+	fmt.Fprintf(&b, "tenants: %v\n", c.Tenants)
+	tenants := make([]string, 0, len(c.Policies))
+	for t := range c.Policies {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+	for _, t := range tenants {
+		p := c.Policies[t]
+		fmt.Fprintf(&b, "policy %s: max age %s, max sessions %d, max bytes %d\n", t, p.MaxAge, p.MaxSessions, p.MaxBytes)
+	}
+	fmt.Fprintf(&b, "skip extensions: %v\n", c.SkipExtensions)
+	fmt.Fprintf(&b, "order: %s\n", c.Order)
+	fmt.Fprintf(&b, "skip open files: %v\n", c.SkipOpenFiles)
+	if c.StateFile != "" {
+		fmt.Fprintf(&b, "state file: %s\n", c.StateFile)
+	}
+	if c.MatchGlob != "" {
+		fmt.Fprintf(&b, "match glob: %s\n", c.MatchGlob)
+	}
+	if c.MatchRegexp != "" {
+		fmt.Fprintf(&b, "match regexp: %s\n", c.MatchRegexp)
+	}
+	return b.String()
+}
@@ -0,0 +1,65 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+// WouldBeCollected returns information about the session referenced by
+// the request's session cookie with the given name. Its Expired field
+// reports whether the next collection would remove the session, and
+// Reason tells why. It doesn't remove anything.
+//
+// The function decodeID must return the session ID from the cookie
+// value, for example, by decoding it with the store's codecs:
+//
+//   func(value string) (string, error) {
+//           var id string
+//           err := securecookie.DecodeMulti(name, value, &id, store.Codecs...)
+//           return id, err
+//   }
+//
+// In multi-tenant mode, it must return the ID prefixed with the tenant
+// name and a slash, as in Restore.
+//
+// If the session file doesn't exist, for example, because it was already
+// removed, the returned error satisfies os.IsNotExist.
+//
+// It is useful for debugging why users get logged out.
+func (gc *GC) WouldBeCollected(r *http.Request, cookieName string, decodeID func(value string) (string, error)) (SessionInfo, error) {
+	c, err := r.Cookie(cookieName)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	id, err := decodeID(c.Value)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	tenant, name, err := gc.splitID(id)
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	dir := filepath.Join(gc.dir, tenant)
+	fi, err := os.Lstat(filepath.Join(dir, name))
+	if os.IsNotExist(err) && gc.coldDir != "" {
+		dir = filepath.Join(gc.coldDir, tenant)
+		fi, err = os.Lstat(filepath.Join(dir, name))
+	}
+	if err != nil {
+		return SessionInfo{}, err
+	}
+	reason, _ := gc.expiryReason(filepath.Join(dir, name), fi, gc.policyFor(tenant), gc.now())
+	info := newSessionInfo(tenant, dir, fi, reason != "")
+	info.Reason = reason
+	return info, nil
+}
@@ -0,0 +1,58 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"math"
+	"sync/atomic"
+)
+
+// OnCreationSpike sets the function called after a collection if the
+// session creation rate exceeds the given threshold in sessions per
+// minute, and returns the same GC. See Report.CreationRate.
+//
+// A sudden spike in creation of sessions, for example, by a crawler,
+// is an early sign that the collector settings need tightening.
+//
+// For background collections, the function is called on the collector
+// goroutine. It may call methods of GC.
+func (gc *GC) OnCreationSpike(threshold float64, f func(rate float64)) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.creationThreshold = threshold
+	gc.onCreationSpike = f
+	return gc
+}
+
+// CreationRate returns the session creation rate in sessions per minute
+// estimated by the last collection. See Report.CreationRate.
+//
+// It doesn't wait for an in-progress collection.
+func (gc *GC) CreationRate() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&gc.stats.creationRate))
+}
+
+// updateCreationRate estimates the number of sessions created since the
+// previous collection and their creation rate, and sets them in the report.
+//
+// Sessions left after the previous collection are all sessions it found
+// minus the removed and vanished ones, so the ones found above that
+// number are new. The estimate is lower than the real number if sessions
+// were removed by the store between collections.
+func (gc *GC) updateCreationRate(r *Report) {
+	if !gc.prevRun.IsZero() && r.Scanned > gc.prevLeft {
+		r.Created = r.Scanned - gc.prevLeft
+		if d := r.Start.Sub(gc.prevRun); d > 0 {
+			r.CreationRate = float64(r.Created) / d.Minutes()
+		}
+	}
+	atomic.StoreUint64(&gc.stats.creationRate, math.Float64bits(r.CreationRate))
+	gc.prevRun = r.Start
+	gc.prevLeft = r.Scanned - r.Removed - r.Vanished
+}
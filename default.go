@@ -0,0 +1,42 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "sync"
+
+var (
+	defaultMu sync.Mutex
+	defaultGC *GC
+)
+
+// Start starts the default collector, which removes sessions older than
+// DefaultMaxAge from the given directory every DefaultInterval, and returns
+// it. If the default collector is already running, it is stopped first.
+//
+// The default collector must be stopped by calling Stop when it is no
+// longer needed.
+func Start(dir string) *GC {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultGC != nil {
+		defaultGC.Stop()
+	}
+	defaultGC = New(dir).Start()
+	return defaultGC
+}
+
+// Stop stops the default collector started by Start.
+func Stop() {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	if defaultGC == nil {
+		return // not started
+	}
+	defaultGC.Stop()
+	defaultGC = nil
+}
@@ -0,0 +1,95 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"strings"
+	"time"
+)
+
+// deleteGroupSamples is the maximum number of session IDs in DeleteGroup.
+const deleteGroupSamples = 10
+
+// DeleteGroup summarizes session files removed by a collection that were
+// not passed individually to the function set by OnDelete. See GroupDeletes.
+type DeleteGroup struct {
+	RunID   string    // identifier of the collection, see GC.RunID
+	Start   time.Time // time of the first removal in the group
+	End     time.Time // time of the last removal in the group
+	Count   int       // number of removed session files
+	Samples []string  // IDs of some of the removed sessions
+}
+
+// GroupDeletes enables grouping of deletion events when removal rates
+// are high, and returns the same GC.
+//
+// In each period of the given duration, the first limit removed session
+// files are passed individually to the function set by OnDelete, and the
+// rest are counted and passed to f as one group at the end of the period
+// or the collection, whichever comes first. This way, consumers of the
+// events aren't flooded by notifications during a big cleanup, but still
+// see individual removals in normal operation. The group includes the IDs
+// of a few removed sessions, which are not redacted by RedactIDs.
+// If f is nil, grouping is disabled.
+//
+// The function is called in the middle of the collection, so it must
+// not call methods of GC, except RunID.
+func (gc *GC) GroupDeletes(limit int, every time.Duration, f func(DeleteGroup)) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.groupLimit = limit
+	gc.groupEvery = every
+	gc.onDeleteGroup = f
+	return gc
+}
+
+// notifyDelete passes the removed session file to the function set by
+// OnDelete or adds it to the current group.
+func (gc *GC) notifyDelete(f sessionFile) {
+	if gc.onDeleteGroup == nil {
+		if gc.onDelete != nil {
+			gc.onDelete(f.path, f.fi)
+		}
+		return
+	}
+	now := time.Now()
+	if gc.groupStart.IsZero() || now.Sub(gc.groupStart) >= gc.groupEvery {
+		gc.flushDeleteGroup()
+		gc.groupStart = now
+	}
+	if gc.groupSeen < gc.groupLimit {
+		gc.groupSeen++
+		if gc.onDelete != nil {
+			gc.onDelete(f.path, f.fi)
+		}
+		return
+	}
+	g := &gc.deleteGroup
+	if g.Count == 0 {
+		g.Start = now
+	}
+	g.End = now
+	g.Count++
+	if len(g.Samples) < deleteGroupSamples {
+		g.Samples = append(g.Samples, strings.TrimPrefix(f.fi.Name(), sessionPrefix))
+	}
+}
+
+// flushDeleteGroup passes the current group, if it's not empty, to the
+// function set by GroupDeletes and starts a new period.
+func (gc *GC) flushDeleteGroup() {
+	if gc.deleteGroup.Count > 0 && gc.onDeleteGroup != nil {
+		g := gc.deleteGroup
+		g.RunID = gc.RunID()
+		gc.onDeleteGroup(g)
+	}
+	gc.deleteGroup = DeleteGroup{}
+	gc.groupStart = time.Time{}
+	gc.groupSeen = 0
+}
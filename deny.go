@@ -0,0 +1,34 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "net/http"
+
+// DenyNewSessions returns a handler that protects the session store from
+// being filled, for example, by a crawler, when it is under critical
+// pressure.
+//
+// When Pressure is at or above the threshold, requests without the session
+// cookie with the given name, which would create new anonymous sessions,
+// are served by deny. If deny is nil, they get 503 Service Unavailable
+// response. Other requests are passed to h.
+func (gc *GC) DenyNewSessions(h http.Handler, cookieName string, threshold float64, deny http.Handler) http.Handler {
+	if deny == nil {
+		deny = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "60")
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := r.Cookie(cookieName); err == http.ErrNoCookie && gc.Pressure() >= threshold {
+			deny.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
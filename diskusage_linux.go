@@ -0,0 +1,20 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "syscall"
+
+// diskUsage returns the fraction of used space on the filesystem
+// containing dir.
+func diskUsage(dir string) (float64, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil || st.Blocks == 0 {
+		return 0, false
+	}
+	return 1 - float64(st.Bavail)/float64(st.Blocks), true
+}
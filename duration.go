@@ -0,0 +1,88 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseDuration parses a duration string for configuring max age and
+// intervals from environment variables, configuration files, or
+// command-line flags.
+//
+// In addition to the units accepted by time.ParseDuration, it accepts
+// "d" for days and "w" for weeks, which are 24 hours and 7 days
+// regardless of daylight saving time, for example:
+//
+//   30m
+//   12h
+//   1d
+//   1w2d12h
+//
+// Negative durations and durations that don't fit into time.Duration
+// are not accepted.
+func ParseDuration(s string) (time.Duration, error) {
+	orig := s
+	s = strings.TrimSpace(s)
+	if s == "" || s[0] == '-' || s[0] == '+' {
+		return 0, errors.New("fsgc: invalid duration " + strconv.Quote(orig))
+	}
+	if s == "0" {
+		return 0, nil
+	}
+	var d time.Duration
+	for s != "" {
+		// Split the next number and unit.
+		i := strings.IndexFunc(s, func(r rune) bool {
+			return (r < '0' || r > '9') && r != '.'
+		})
+		if i <= 0 {
+			return 0, errors.New("fsgc: invalid duration " + strconv.Quote(orig))
+		}
+		j := i + strings.IndexFunc(s[i:], func(r rune) bool {
+			return (r >= '0' && r <= '9') || r == '.'
+		})
+		if j < i {
+			j = len(s)
+		}
+		num, unit := s[:i], s[i:j]
+		s = s[j:]
+		var v time.Duration
+		switch unit {
+		case "d", "w":
+			n, err := strconv.ParseFloat(num, 64)
+			if err != nil {
+				return 0, errors.New("fsgc: invalid duration " + strconv.Quote(orig))
+			}
+			day := 24 * time.Hour
+			if unit == "w" {
+				day *= 7
+			}
+			f := n * float64(day)
+			if f >= math.MaxInt64 { // float64(math.MaxInt64) is 1<<63
+				return 0, errors.New("fsgc: invalid duration " + strconv.Quote(orig))
+			}
+			v = time.Duration(f)
+		default:
+			var err error
+			v, err = time.ParseDuration(num + unit)
+			if err != nil {
+				return 0, errors.New("fsgc: invalid duration " + strconv.Quote(orig))
+			}
+		}
+		if v > math.MaxInt64-d {
+			return 0, errors.New("fsgc: invalid duration " + strconv.Quote(orig))
+		}
+		d += v
+	}
+	return d, nil
+}
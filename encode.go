@@ -0,0 +1,229 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// ReportVersion is the version of the schema of encoded reports and
+// statistics, included in them as the "version" field. It changes when
+// fields are renamed, removed, or change their meaning, but not when new
+// fields are added, so tools parsing the output should ignore unknown
+// fields.
+const ReportVersion = 1
+
+// ReportEncoder encodes reports.
+//
+// All encoders use the same schema, so that reports are consistent
+// regardless of where they are written to.
+type ReportEncoder interface {
+	Encode(w io.Writer, r Report) error
+}
+
+// JSONReportEncoder encodes reports as JSON objects followed by a newline.
+//
+// Durations are in seconds, times are in RFC 3339 format, and errors
+// are strings.
+type JSONReportEncoder struct{}
+
+// LogfmtReportEncoder encodes reports as single lines of key=value pairs
+// in logfmt format.
+//
+// It uses the same keys as JSONReportEncoder. Nested values are flattened
+// with dots, for example, "reasons.age=10", and errors are only counted.
+type LogfmtReportEncoder struct{}
+
+// encodedCounts is the schema of encoded Counts.
+type encodedCounts struct {
+	Scanned   int `json:"scanned"`
+	Expired   int `json:"expired"`
+	Removed   int `json:"removed"`
+	Vanished  int `json:"vanished"`
+	InUse     int `json:"in_use"`
+	Failed    int `json:"failed"`
+	Artifacts int `json:"artifacts"`
+	Pinned    int `json:"pinned"`
+
+	Freed int64 `json:"freed"`
+}
+
+// encodedReport is the schema of encoded Report.
+type encodedReport struct {
+	Version  int     `json:"version"`
+	RunID    string  `json:"run_id,omitempty"`
+	Start    string  `json:"start,omitempty"`
+	Duration float64 `json:"duration"`
+	encodedCounts
+	Lag            float64                  `json:"lag"`
+	Created        int                      `json:"created"`
+	CreationRate   float64                  `json:"creation_rate"`
+	Reasons        map[Reason]int           `json:"reasons,omitempty"`
+	Errors         []string                 `json:"errors,omitempty"`
+	BudgetExceeded bool                     `json:"budget_exceeded"`
+	Skipped        bool                     `json:"skipped"`
+	WarmUp         bool                     `json:"warm_up"`
+	Clamped        bool                     `json:"clamped"`
+	Anomaly        bool                     `json:"anomaly"`
+	Lifetimes      *encodedLifetimes        `json:"lifetimes,omitempty"`
+	SelfCheck      encodedSelfCheck         `json:"self_check"`
+	Tenants        map[string]encodedCounts `json:"tenants,omitempty"`
+}
+
+type encodedLifetimes struct {
+	Count   int     `json:"count"`
+	Unknown int     `json:"unknown"`
+	P50     float64 `json:"p50"`
+	P90     float64 `json:"p90"`
+	P99     float64 `json:"p99"`
+	Max     float64 `json:"max"`
+}
+
+type encodedSelfCheck struct {
+	Sampled int `json:"sampled"`
+	Failed  int `json:"failed"`
+}
+
+func encodeCounts(c Counts) encodedCounts {
+	return encodedCounts(c)
+}
+
+func encodeReport(r Report) encodedReport {
+	e := encodedReport{
+		Version:        ReportVersion,
+		RunID:          r.RunID,
+		Duration:       r.Duration.Seconds(),
+		encodedCounts:  encodeCounts(r.Counts),
+		Lag:            r.Lag.Seconds(),
+		Created:        r.Created,
+		CreationRate:   r.CreationRate,
+		Reasons:        r.Reasons,
+		BudgetExceeded: r.BudgetExceeded,
+		Skipped:        r.Skipped,
+		WarmUp:         r.WarmUp,
+		Clamped:        r.Clamped,
+		Anomaly:        r.Anomaly,
+		SelfCheck:      encodedSelfCheck{r.SelfCheck.Sampled, len(r.SelfCheck.Failed)},
+	}
+	if l := r.Lifetimes; l.Count > 0 || l.Unknown > 0 {
+		e.Lifetimes = &encodedLifetimes{
+			Count:   l.Count,
+			Unknown: l.Unknown,
+			P50:     l.P50.Seconds(),
+			P90:     l.P90.Seconds(),
+			P99:     l.P99.Seconds(),
+			Max:     l.Max.Seconds(),
+		}
+	}
+	if !r.Start.IsZero() {
+		e.Start = r.Start.Format(time.RFC3339Nano)
+	}
+	for _, err := range r.Errors {
+		e.Errors = append(e.Errors, err.Error())
+	}
+	if len(r.Tenants) > 0 {
+		e.Tenants = make(map[string]encodedCounts, len(r.Tenants))
+		for t, c := range r.Tenants {
+			e.Tenants[t] = encodeCounts(c)
+		}
+	}
+	return e
+}
+
+// Encode writes the JSON encoding of the report to w.
+func (JSONReportEncoder) Encode(w io.Writer, r Report) error {
+	return json.NewEncoder(w).Encode(encodeReport(r))
+}
+
+// Encode writes the logfmt encoding of the report to w.
+func (LogfmtReportEncoder) Encode(w io.Writer, r Report) error {
+	e := encodeReport(r)
+	var b bytes.Buffer
+	kv := func(k, v string) {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(v)
+	}
+	counts := func(prefix string, c encodedCounts) {
+		kv(prefix+"scanned", strconv.Itoa(c.Scanned))
+		kv(prefix+"expired", strconv.Itoa(c.Expired))
+		kv(prefix+"removed", strconv.Itoa(c.Removed))
+		kv(prefix+"vanished", strconv.Itoa(c.Vanished))
+		kv(prefix+"in_use", strconv.Itoa(c.InUse))
+		kv(prefix+"failed", strconv.Itoa(c.Failed))
+		kv(prefix+"artifacts", strconv.Itoa(c.Artifacts))
+		kv(prefix+"pinned", strconv.Itoa(c.Pinned))
+		kv(prefix+"freed", strconv.FormatInt(c.Freed, 10))
+	}
+	kv("version", strconv.Itoa(e.Version))
+	if e.RunID != "" {
+		kv("run_id", e.RunID)
+	}
+	if e.Start != "" {
+		kv("start", e.Start)
+	}
+	kv("duration", strconv.FormatFloat(e.Duration, 'f', -1, 64))
+	counts("", e.encodedCounts)
+	kv("lag", strconv.FormatFloat(e.Lag, 'f', -1, 64))
+	kv("created", strconv.Itoa(e.Created))
+	kv("creation_rate", strconv.FormatFloat(e.CreationRate, 'f', -1, 64))
+	reasons := make([]string, 0, len(e.Reasons))
+	for reason := range e.Reasons {
+		reasons = append(reasons, string(reason))
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		kv("reasons."+reason, strconv.Itoa(e.Reasons[Reason(reason)]))
+	}
+	kv("errors", strconv.Itoa(len(e.Errors)))
+	kv("budget_exceeded", strconv.FormatBool(e.BudgetExceeded))
+	kv("skipped", strconv.FormatBool(e.Skipped))
+	kv("warm_up", strconv.FormatBool(e.WarmUp))
+	kv("clamped", strconv.FormatBool(e.Clamped))
+	kv("anomaly", strconv.FormatBool(e.Anomaly))
+	if l := e.Lifetimes; l != nil {
+		kv("lifetimes.count", strconv.Itoa(l.Count))
+		kv("lifetimes.unknown", strconv.Itoa(l.Unknown))
+		kv("lifetimes.p50", strconv.FormatFloat(l.P50, 'f', -1, 64))
+		kv("lifetimes.p90", strconv.FormatFloat(l.P90, 'f', -1, 64))
+		kv("lifetimes.p99", strconv.FormatFloat(l.P99, 'f', -1, 64))
+		kv("lifetimes.max", strconv.FormatFloat(l.Max, 'f', -1, 64))
+	}
+	kv("self_check.sampled", strconv.Itoa(e.SelfCheck.Sampled))
+	kv("self_check.failed", strconv.Itoa(e.SelfCheck.Failed))
+	tenants := make([]string, 0, len(e.Tenants))
+	for t := range e.Tenants {
+		tenants = append(tenants, t)
+	}
+	sort.Strings(tenants)
+	for _, t := range tenants {
+		counts("tenants."+logfmtKey(t)+".", e.Tenants[t])
+	}
+	b.WriteByte('\n')
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// logfmtKey replaces characters not allowed in logfmt keys.
+func logfmtKey(s string) string {
+	b := []byte(s)
+	for i, c := range b {
+		if c <= ' ' || c == '=' || c == '"' {
+			b[i] = '_'
+		}
+	}
+	return string(b)
+}
@@ -0,0 +1,111 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"errors"
+	"regexp"
+)
+
+// ErrFrozen is the value methods changing the configuration panic with
+// after Freeze.
+var ErrFrozen = errors.New("fsgc: configuration is frozen, use Reconfigure to change it")
+
+// Freeze freezes the configuration of the collector and returns the same
+// GC. After that, calling any method that changes the configuration, such
+// as MaxAge or Interval, panics with ErrFrozen, and the configuration can
+// only be changed with Reconfigure.
+//
+// Freezing the configuration after setting up the collector, usually right
+// before Start, turns mistakes such as calling setters from a different
+// part of the program, which may or may not affect the running collector,
+// into loud failures.
+func (gc *GC) Freeze() *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.frozen = true
+	return gc
+}
+
+// checkFrozen panics with ErrFrozen if the configuration is frozen.
+// It must be called with the lock held.
+func (gc *GC) checkFrozen() {
+	if gc.frozen {
+		panic(ErrFrozen)
+	}
+}
+
+// Reconfigure changes the configuration of the collector, which may be
+// started and frozen. It calls f with the current configuration (see
+// Config), validates the changed configuration, and, if it is valid,
+// applies it at once, so that collections use either the old or the new
+// configuration. Otherwise, it returns the validation error and doesn't
+// change anything. This way, changing several settings, for example,
+// from a reloaded configuration file, never leaves the collector in
+// a half-updated state:
+//
+//   err := gc.Reconfigure(func(c *fsgc.Config) {
+//           c.MaxAge = newConf.MaxAge
+//           c.Interval = newConf.Interval
+//           c.MatchGlob = newConf.Prefix + "*"
+//           c.Hooks.OnDelete = audit
+//   })
+//
+// If the interval between collections changes, the started collector
+// switches to the new interval immediately. If the state file changes,
+// statistics are loaded from it.
+//
+// Reconfigure waits for the in-progress collection to finish. The function
+// is called with the collector locked, so it must not call methods of GC.
+func (gc *GC) Reconfigure(f func(*Config)) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	c := gc.configLocked()
+	f(&c)
+	if err := c.Validate(); err != nil {
+		return err
+	}
+	gc.dir = c.Dir
+	gc.dirValue.Store(c.Dir)
+	gc.extraDirs = append([]string(nil), c.ExtraDirs...)
+	gc.maxAge = c.MaxAge
+	gc.auto = c.AutoInterval
+	if !c.AutoInterval {
+		gc.interval = c.Interval
+	}
+	gc.precise = c.Precise
+	gc.coldDir, gc.coldAfter = c.ColdDir, c.ColdAfter
+	gc.tenants = c.Tenants
+	gc.policies = make(map[string]Policy, len(c.Policies))
+	for t, p := range c.Policies {
+		gc.policies[t] = p
+	}
+	gc.skipExts = append([]string(nil), c.SkipExtensions...)
+	gc.order = c.Order
+	gc.skipOpen = c.SkipOpenFiles
+	if c.StateFile != gc.stateFile {
+		gc.stateFile = c.StateFile
+		if c.StateFile != "" {
+			gc.loadState()
+		}
+	}
+	switch {
+	case c.MatchGlob != gc.matchGlob && c.MatchGlob != "":
+		gc.setMatchGlob(c.MatchGlob)
+	case c.MatchRegexp != gc.matchRegexp && c.MatchRegexp != "":
+		gc.setMatchRegexp(regexp.MustCompile(c.MatchRegexp)) // validated
+	case c.MatchGlob == "" && c.MatchRegexp == "" && (gc.matchGlob != "" || gc.matchRegexp != ""):
+		gc.setMatchGlob("") // back to the default prefix
+	}
+	gc.onRunStart = c.Hooks.OnRunStart
+	gc.onRunEnd = c.Hooks.OnRunEnd
+	gc.onError = c.Hooks.OnError
+	gc.onDelete = c.Hooks.OnDelete
+	gc.rescheduleLocked()
+	return nil
+}
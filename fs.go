@@ -0,0 +1,73 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+//go:build go1.16
+// +build go1.16
+
+package fsgc
+
+import (
+	"errors"
+	"io/fs"
+)
+
+// Remover removes files from a filesystem. See CollectFS.
+type Remover interface {
+	// Remove removes the file with the given name, which is a path
+	// valid for fs.FS.
+	Remove(name string) error
+}
+
+// CollectFS removes expired session files from the root directory of
+// the given filesystem, using rm to remove them, and returns the report
+// describing the results. It allows running the collector against
+// virtual, in-memory, or wrapped filesystems, for example, in tests.
+//
+// CollectFS applies max age, ExpireFunc, the session file matcher, and
+// skipped extensions of the collector, but not features that depend on
+// the operating system, such as multi-tenant mode, cold storage, trash,
+// quotas, or extended attributes. It doesn't affect statistics, history,
+// or the schedule of the collector.
+func (gc *GC) CollectFS(fsys fs.FS, rm Remover) (Report, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	var r Report
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return r, err
+	}
+	p := gc.policyFor("")
+	now := gc.now()
+	for _, e := range entries {
+		if e.IsDir() || !gc.isSession(e.Name()) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			return r, err
+		}
+		r.Scanned++
+		if !gc.isExpired(p, fi, now) {
+			continue
+		}
+		r.Expired++
+		switch err := rm.Remove(e.Name()); {
+		case err == nil:
+			r.Removed++
+			r.Freed += fi.Size()
+		case errors.Is(err, fs.ErrNotExist):
+			r.Vanished++
+		default:
+			r.Failed++
+			r.Errors = append(r.Errors, gc.redactError(err))
+		}
+	}
+	return r, nil
+}
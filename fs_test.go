@@ -0,0 +1,48 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+//go:build go1.16
+// +build go1.16
+
+package fsgc
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+// mapRemover removes files from fstest.MapFS.
+type mapRemover fstest.MapFS
+
+func (m mapRemover) Remove(name string) error {
+	if _, ok := m[name]; !ok {
+		return fs.ErrNotExist
+	}
+	delete(m, name)
+	return nil
+}
+
+func TestCollectFS(t *testing.T) {
+	now := time.Now()
+	fsys := fstest.MapFS{
+		"session_1": {Data: []byte("session"), ModTime: now.Add(-2 * time.Hour)},
+		"session_2": {Data: []byte("session"), ModTime: now},
+		"other":     {Data: []byte("other"), ModTime: now.Add(-2 * time.Hour)},
+	}
+	r, err := New("").MaxAge(time.Hour).CollectFS(fsys, mapRemover(fsys))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != 2 || r.Removed != 1 || r.Freed != int64(len("session")) {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+	if _, ok := fsys["session_1"]; ok {
+		t.Fatal("fsgc: expired session is not removed")
+	}
+}
@@ -24,20 +24,192 @@
 package fsgc
 
 import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // GC is a garbage collector.
 type GC struct {
+	// stats must be first to be 64-bit aligned on 32-bit platforms.
+	stats statCounters
+
 	mu       sync.Mutex
 	dir      string
 	maxAge   time.Duration
 	interval time.Duration
-	ticker   *time.Ticker
+	auto     bool // derive interval from maxAge
+	ticker   Ticker
+	stop     chan struct{}
+	done     chan struct{} // closed when the collector goroutine exits
+	now      func() time.Time
+	clock    Clock
+
+	tickerInterval time.Duration // interval of the ticker
+	reschedule     chan struct{} // notifies the run loop of a new ticker
+
+	precise    bool
+	nextExpiry time.Time
+
+	coldDir   string
+	coldAfter time.Duration
+
+	tenants   bool
+	policies  map[string]Policy
+	extraDirs []string // added by AddDir
+	recursive bool
+
+	sessionMaxAge func(data []byte) (time.Duration, bool)
+
+	byAccess bool // expire by access time
+	noatime  bool // access times are not updated
+
+	lifetimeAnalytics bool
+	lifetimes         []time.Duration // lifetimes of sessions removed by the collection
+	lifetimeUnknown   int             // removed sessions with unknown creation time
+
+	skipExts []string
+	order    DeleteOrder
+	skipOpen bool
+	budget   time.Duration
+
+	trashDir    string
+	trashMaxAge time.Duration
+	trashRun    string // trash subdirectory for the current collection
+
+	maxLifetime time.Duration
+	ceiling     time.Duration
+	xattrs      bool
+
+	keepAll time.Duration
+	userID  func(SessionInfo) string
+
+	artifactExts []string
+	artifactAge  time.Duration
+
+	stateFile string
+
+	onRunStart func() bool
+	onRunEnd   func(Report)
+	onError    func(error)
+	onDelete   func(path string, fi os.FileInfo)
+	errs       chan error
+
+	onDeleteGroup func(DeleteGroup)
+	groupLimit    int
+	groupEvery    time.Duration
+	groupStart    time.Time   // start of the current period
+	groupSeen     int         // removals passed to onDelete in the period
+	deleteGroup   DeleteGroup // removals grouped in the period
+
+	unreadable      UnreadablePolicy
+	unreadableSince map[string]time.Time // when unreadable files were found
+	unreadableLast  map[string]time.Time // unreadableSince of last collection
+
+	paused bool
+	frozen bool // see Freeze
+
+	selfCheckN int
+
+	runID       atomic.Value // string, see RunID
+	lastResult  atomic.Value // result, see LastResult
+	interrupted int32        // set by StopBefore, accessed atomically
+	exited      atomic.Value // chan struct{}, see Done
+	dirValue    atomic.Value // string, dir read by Pressure without locking
+
+	logger   *log.Logger
+	redact   func(id string) string
+	fileErrs []error
+
+	removedBy map[Reason]int
+
+	fatalIf func(error) bool
+	fatal   chan error
+
+	syncMaxAgeFunc func() int
+	syncEvery      time.Duration
+
+	lag          time.Duration
+	leftLag      time.Duration // lag of expired files left after the sweep
+	lagThreshold time.Duration
+	onLag        func(time.Duration)
+
+	history     []Report // ring buffer of the last reports
+	historyNext int      // index of the next report in history
+
+	warmUp         bool
+	collectOnStart bool
+	jitter         time.Duration
+
+	creationThreshold float64
+	onCreationSpike   func(rate float64)
+	prevRun           time.Time // start of the previous collection
+	prevLeft          int       // number of sessions left by the previous collection
+
+	anomalyFactor  float64
+	anomalyConfirm func(average float64, expired int) bool
+	recentRemoved  []int // numbers of sessions removed by recent collections
+
+	match       func(name string) bool
+	matchGlob   string
+	matchRegexp string
+
+	expireFunc func(fi os.FileInfo, now time.Time) bool
+
+	verify       bool
+	removedFiles []sessionFile // files removed from the current directory
+
+	progressEvery time.Duration
+	onProgress    func(Progress)
+	progress      progressState
+
+	strictMemory bool
+
+	syncBatch   int
+	syncDirs    map[string]bool // directories with pending removals
+	syncPending int             // number of pending removals
+
+	maxRate     float64
+	cgroupAware bool
+	rateWindows []RateWindow
+	cgroupRate  float64   // cgroup removal rate limit for the current collection
+	paceNext    time.Time // time of the next allowed removal
+
+	latencyThreshold time.Duration
+	probe            *os.File // latency probe file of the current collection
+	sinceProbe       int      // removals since the last latency probe
+
+	indexed   bool
+	indexMu   sync.RWMutex
+	index     map[string]indexEntry
+	nextIndex map[string]indexEntry // index built by the current collection
+
+	firstRunMax     float64
+	firstRunConfirm func(scanned, expired int) bool
+	firstRunDone    bool
+
+	retries      int
+	retryWait    time.Duration
+	retryAttempt int           // number of the current retry
+	failedFiles  []sessionFile // files to retry removing
+
+	ctx context.Context // context of the current collection
+
+	memFSPolicy Policy
+	memFSAware  bool                 // set by MemoryFSPolicy
+	memFSDirs   map[string]memFSInfo // filesystems of directories
+
+	pinned map[string]*pinnedDir // directories opened by the current collection
+	abort  error                 // error that stopped the current collection
 }
 
 const (
@@ -50,6 +222,10 @@ const (
 	DefaultInterval = 1 * time.Hour
 )
 
+// sessionPrefix is the prefix of session file names created by
+// FilesystemStore.
+const sessionPrefix = "session_"
+
 // New returns a new collector, which will remove expired sessions
 // from the given directory. It must be started by calling Start.
 //
@@ -59,26 +235,321 @@ const (
 // The garbage collector will try to collect every DefaultInterval.
 // To set a different interval between collections, call Interval.
 func New(dir string) *GC {
-	return &GC{
+	gc := &GC{
 		dir:      dir,
 		maxAge:   DefaultMaxAge,
 		interval: DefaultInterval,
+		now:      time.Now,
+		clock:    realClock{},
+
+		trashMaxAge: DefaultTrashMaxAge,
 	}
+	gc.dirValue.Store(dir)
+	return gc
 }
 
 // MaxAge sets the max age for the session and returns the same GC.
+//
+// The new max age applies to the next collection. If the collector is
+// started with AutoInterval, the derived interval applies immediately.
 func (gc *GC) MaxAge(dur time.Duration) *GC {
 	gc.mu.Lock()
 	defer gc.mu.Unlock()
+	gc.checkFrozen()
 	gc.maxAge = dur
+	gc.rescheduleLocked()
 	return gc
 }
 
 // Interval sets the interval between collections and returns the same GC.
+//
+// If the collector is started, the new interval applies immediately:
+// the next collection happens after the new interval passes since the
+// call.
 func (gc *GC) Interval(dur time.Duration) *GC {
 	gc.mu.Lock()
 	defer gc.mu.Unlock()
+	gc.checkFrozen()
 	gc.interval = dur
+	gc.auto = false
+	gc.rescheduleLocked()
+	return gc
+}
+
+const (
+	// autoIntervalDivisor is the fraction of max age used as
+	// an interval by AutoInterval.
+	autoIntervalDivisor = 10
+
+	// minAutoInterval and maxAutoInterval limit the interval
+	// set by AutoInterval.
+	minAutoInterval = 1 * time.Minute
+	maxAutoInterval = 6 * time.Hour
+)
+
+// AutoInterval makes the collector derive the interval between collections
+// from the max age of sessions, and returns the same GC.
+//
+// The interval is one tenth of max age, but no less than a minute and
+// no more than six hours. Calling Interval disables automatic interval.
+func (gc *GC) AutoInterval() *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.auto = true
+	gc.rescheduleLocked()
+	return gc
+}
+
+// currentInterval returns the interval between collections.
+func (gc *GC) currentInterval() time.Duration {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.effectiveInterval()
+}
+
+// effectiveInterval returns the interval between collections.
+func (gc *GC) effectiveInterval() time.Duration {
+	if !gc.auto {
+		return gc.interval
+	}
+	d := gc.clampMaxAge(gc.maxAge) / autoIntervalDivisor
+	if d < minAutoInterval {
+		d = minAutoInterval
+	}
+	if d > maxAutoInterval {
+		d = maxAutoInterval
+	}
+	return d
+}
+
+// SkipExtensions sets file name extensions, such as ".lock" or ".tmp",
+// and returns the same GC. Files with these extensions are never removed,
+// even if their names start with the session prefix.
+func (gc *GC) SkipExtensions(exts ...string) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.skipExts = exts
+	return gc
+}
+
+// hasSkippedExt reports whether the file name has a skipped extension.
+func (gc *GC) hasSkippedExt(name string) bool {
+	for _, ext := range gc.skipExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Artifacts enables removal of files with the given extensions, such as
+// ".tmp" or ".lock", left next to session files by store wrappers, and
+// returns the same GC. Such files are removed when they are older than
+// the given age, which is usually much shorter than the max age of sessions.
+// Passing no extensions disables removal of artifacts.
+//
+// Only files with names starting with the session prefix are considered.
+// Artifacts are removed even if their extensions are set by SkipExtensions.
+func (gc *GC) Artifacts(age time.Duration, exts ...string) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.artifactExts = exts
+	gc.artifactAge = age
+	return gc
+}
+
+// Budget sets the maximum duration of removing sessions during a collection,
+// and returns the same GC. If the budget is exceeded, the remaining expired
+// sessions are left for the next collection. Zero means no limit.
+//
+// In multi-tenant mode, tenant directories with the most expired sessions
+// are cleaned first.
+func (gc *GC) Budget(d time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.budget = d
+	return gc
+}
+
+// SkipOpenFiles enables or disables checking whether expired session files
+// are currently open by this process, for example, by FilesystemStore in the
+// middle of saving a session, and returns the same GC. Open files are
+// not removed.
+//
+// The check is only supported on Linux; on other systems it does nothing.
+func (gc *GC) SkipOpenFiles(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.skipOpen = enable
+	return gc
+}
+
+// isOpen reports whether the file is in the set of open files.
+func isOpen(open map[string]bool, name string) bool {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return false
+	}
+	return open[abs]
+}
+
+// Logger sets the logger for reporting errors that happen during collections,
+// and returns the same GC. If l is nil, errors are not logged.
+//
+// Identical errors that happen with many session files during a collection,
+// such as "permission denied", are logged once with the number of files.
+func (gc *GC) Logger(l *log.Logger) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.logger = l
+	return gc
+}
+
+// Precise enables or disables precise expiry scheduling and returns the same
+// GC.
+//
+// In precise mode, in addition to running every interval, the collector
+// schedules a collection for the time when the next session expires, so
+// that sessions are removed shortly after they expire rather than up to
+// the full interval later. It also runs a collection immediately after
+// starting to learn expiry times.
+func (gc *GC) Precise(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.precise = enable
+	return gc
+}
+
+// Jitter sets the maximum random deviation of scheduled collections from
+// the interval, and returns the same GC.
+//
+// Each collection scheduled by the interval is delayed by a random
+// duration between zero and 2*d, so collections happen d later than
+// the interval on average, give or take d. This spreads collections of
+// multiple instances sharing a session directory, for example,
+// over NFS, so that they don't all hit the filesystem at the same time.
+// Collections scheduled by Precise are not delayed.
+func (gc *GC) Jitter(d time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.jitter = d
+	return gc
+}
+
+// jitterDelay returns a random delay for the scheduled collection.
+func (gc *GC) jitterDelay() time.Duration {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.jitter <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(2 * gc.jitter)))
+}
+
+// CollectOnStart enables or disables running a collection immediately
+// after the collector is started, and returns the same GC.
+//
+// By default, the first collection happens after the set interval,
+// so a server restarted after a long downtime would keep stale sessions
+// for up to the interval.
+func (gc *GC) CollectOnStart(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.collectOnStart = enable
+	return gc
+}
+
+// preciseSlack is added to the time until the next session expiration
+// to make sure that it is expired when the collection runs.
+const preciseSlack = 10 * time.Millisecond
+
+// untilNextExpiry returns the duration until the next session expires,
+// if it is known and is shorter than the collection interval.
+func (gc *GC) untilNextExpiry() (time.Duration, bool) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if !gc.precise || gc.nextExpiry.IsZero() {
+		return 0, false
+	}
+	d := gc.nextExpiry.Sub(gc.now()) + preciseSlack
+	if d < 0 {
+		d = 0
+	}
+	return d, d < gc.effectiveInterval()
+}
+
+// ColdDir sets the cold storage directory and returns the same GC.
+//
+// Sessions older than the given age, but not yet expired, are moved
+// by the collector from the session directory into the cold directory,
+// where they stay until they expire. Such sessions can be brought back
+// by calling Restore, for example, from a store wrapper when a session
+// is not found in the session directory.
+//
+// The cold directory must be on the same filesystem as the session
+// directory. If dir is empty, cold storage is disabled.
+func (gc *GC) ColdDir(dir string, after time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.coldDir = dir
+	gc.coldAfter = after
+	return gc
+}
+
+// Restore moves the session with the given ID from the cold directory
+// back into the session directory. In multi-tenant mode, the ID must be
+// prefixed with the tenant name and a slash, for example, "acme/ABCDEF".
+func (gc *GC) Restore(id string) error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.coldDir == "" {
+		return errors.New("fsgc: cold directory is not set")
+	}
+	sub, name, err := gc.splitID(id)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filepath.Join(gc.coldDir, sub, name), filepath.Join(gc.dir, sub, name))
+}
+
+// splitID returns the tenant and the session file name for the session ID.
+// In multi-tenant mode, the ID must be prefixed with the tenant name.
+func (gc *GC) splitID(id string) (tenant, name string, err error) {
+	name = gc.fileName(path.Base(id))
+	if gc.tenants {
+		tenant = path.Dir(id)
+		if tenant == "." || tenant == ".." || strings.Contains(tenant, "/") {
+			return "", "", errors.New("fsgc: invalid tenant in session ID")
+		}
+	}
+	return tenant, name, nil
+}
+
+// WithNowFunc sets the function used to get the current time when deciding
+// whether sessions are expired, and returns the same GC. If f is nil,
+// time.Now is used.
+//
+// The function doesn't affect scheduling of collections, which always uses
+// the real clock. It is useful for evaluating what would expire as of some
+// other time, for example, in tests.
+func (gc *GC) WithNowFunc(f func() time.Time) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	if f == nil {
+		f = time.Now
+	}
+	gc.now = f
 	return gc
 }
 
@@ -87,57 +558,912 @@ func (gc *GC) Interval(dur time.Duration) *GC {
 // The collector runs on its own goroutine, and must be stopped by calling Stop
 // when it is no longer needed.
 //
-// The first collection will happen after the set interval.
+// The first collection will happen after the set interval, unless
+// CollectOnStart or Precise is enabled.
+//
+// If the wall clock jumps forward by more than the interval, for example,
+// after the host wakes from suspend, the collector notices it within
+// a minute and collects immediately instead of waiting for the next tick.
 func (gc *GC) Start() *GC {
 	gc.mu.Lock()
 	defer gc.mu.Unlock()
+	gc.startLocked()
+	return gc
+}
+
+// startLocked starts the garbage collector unless it is already started.
+// It must be called with the lock held.
+func (gc *GC) startLocked() {
 	if gc.ticker != nil {
-		return gc // already started
+		return // already started
 	}
-	gc.ticker = time.NewTicker(gc.interval)
-	go func() {
-		for _ = range gc.ticker.C {
-			gc.Collect() // ignore error
+	gc.tickerInterval = gc.effectiveInterval()
+	gc.ticker = gc.clock.NewTicker(gc.tickerInterval)
+	gc.reschedule = make(chan struct{}, 1)
+	gc.stop = make(chan struct{})
+	gc.done = make(chan struct{})
+	gc.exited.Store(gc.done)
+	atomic.StoreInt64(&gc.stats.started, time.Now().UnixNano())
+	atomic.StoreInt32(&gc.interrupted, 0)
+	go gc.run(gc.ticker, gc.reschedule, gc.stop, gc.done)
+}
+
+// rescheduleLocked replaces the ticker of the started collector if
+// the interval between collections has changed. It must be called with
+// the lock held.
+func (gc *GC) rescheduleLocked() {
+	if gc.ticker == nil {
+		return // not started
+	}
+	d := gc.effectiveInterval()
+	if d == gc.tickerInterval {
+		return
+	}
+	gc.ticker.Stop()
+	gc.ticker = gc.clock.NewTicker(d)
+	gc.tickerInterval = d
+	select {
+	case gc.reschedule <- struct{}{}:
+	default:
+		// The run loop hasn't picked up the previous change yet.
+	}
+}
+
+// run runs collections on every tick until stop is closed,
+// and then closes done. When notified through reschedule,
+// it switches to the current ticker.
+func (gc *GC) run(ticker Ticker, reschedule, stop, done chan struct{}) {
+	defer close(done)
+	gc.mu.Lock()
+	warmUp := gc.warmUp
+	gc.mu.Unlock()
+	if warmUp {
+		gc.scanOnly()
+	}
+	var timer, jitterTimer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+		if jitterTimer != nil {
+			jitterTimer.Stop()
 		}
 	}()
-	return gc
+	gc.mu.Lock()
+	immediate := gc.precise || gc.collectOnStart
+	gc.mu.Unlock()
+	if immediate {
+		// Collect immediately to start cleanup right away
+		// and, in precise mode, to learn when sessions expire.
+		timer = time.NewTimer(0)
+	}
+	var syncC <-chan time.Time
+	if f, every := gc.storeSync(); f != nil {
+		gc.syncMaxAge(f)
+		t := time.NewTicker(every)
+		defer t.Stop()
+		syncC = t.C
+	}
+	gc.mu.Lock()
+	interval := gc.effectiveInterval()
+	gc.mu.Unlock()
+	// Detect wall clock jumps, such as after suspend, to catch up.
+	catchUp := time.NewTicker(catchUpCheck)
+	defer catchUp.Stop()
+	lastWall := time.Now().UnixNano()
+	now := time.Now()
+	nextTick := now.Add(interval)
+	var timerAt, jitterAt time.Time
+	if timer != nil {
+		timerAt = now
+	}
+	for {
+		var timerC, jitterC <-chan time.Time
+		next := nextTick
+		if timer != nil {
+			timerC = timer.C
+			if timerAt.Before(next) {
+				next = timerAt
+			}
+		}
+		if jitterTimer != nil {
+			jitterC = jitterTimer.C
+			if jitterAt.Before(next) {
+				next = jitterAt
+			}
+		}
+		gc.setNextRun(next)
+		select {
+		case <-ticker.C():
+			nextTick = time.Now().Add(interval)
+			if d := gc.jitterDelay(); d > 0 {
+				// Delay the collection.
+				if jitterTimer == nil {
+					jitterTimer = time.NewTimer(d)
+					jitterAt = time.Now().Add(d)
+				}
+				continue
+			}
+		case <-jitterC:
+			jitterTimer = nil
+		case <-timerC:
+		case <-syncC:
+			f, _ := gc.storeSync()
+			gc.syncMaxAge(f)
+			continue
+		case <-catchUp.C:
+			wall := time.Now().UnixNano()
+			gap := wallGap(lastWall, wall, catchUpCheck)
+			lastWall = wall
+			if gap < interval {
+				continue
+			}
+			gc.logCatchUp(gap)
+		case <-reschedule:
+			gc.mu.Lock()
+			if gc.ticker != nil {
+				ticker = gc.ticker
+				interval = gc.tickerInterval
+			}
+			gc.mu.Unlock()
+			nextTick = time.Now().Add(interval)
+			continue
+		case <-stop:
+			return
+		}
+		if r, err := gc.collectBackground(); !r.Skipped {
+			gc.handleReport(r, err)
+		}
+		if timer != nil {
+			timer.Stop()
+			timer = nil
+		}
+		if d, ok := gc.untilNextExpiry(); ok {
+			timer = time.NewTimer(d)
+			timerAt = time.Now().Add(d)
+		}
+	}
 }
 
 // Stop stops the garbage collector.
 // It can be restarted again by calling Start.
+//
+// Like most methods, Stop waits for the in-progress collection, if any,
+// to finish, which may take long if removals are slowed down, for example,
+// by MaxRemovalRate, RetryFailed, or YieldOnLatency. To interrupt the
+// collection, use StopBefore. See also StopAndWait.
 func (gc *GC) Stop() {
 	gc.mu.Lock()
 	defer gc.mu.Unlock()
+	gc.stopLocked()
+}
+
+// StopAndWait stops the garbage collector like Stop, and waits until
+// the in-progress background collection, if any, finishes, as well as
+// the session removal part of a collection started by Collect on another
+// goroutine. After it returns, the collector doesn't touch the session
+// directory until it is started again or another collection is run,
+// so the directory can be safely unmounted or archived.
+func (gc *GC) StopAndWait() {
+	gc.mu.Lock()
+	done := gc.done
+	gc.stopLocked()
+	gc.mu.Unlock()
+	if done != nil {
+		<-done
+	}
+	// Wait for removals by collections on other goroutines.
+	gc.mu.Lock()
+	gc.mu.Unlock()
+}
+
+// ErrStopped is returned by collections interrupted by StopBefore.
+var ErrStopped = errors.New("fsgc: collector stopped")
+
+// StopBefore stops the garbage collector like StopAndWait, but also
+// interrupts the in-progress collection, and waits no longer than until
+// the context is done, in which case it returns the context error.
+//
+// It is intended for graceful shutdown, to make sure that the collector
+// no longer touches the session directory before it is unmounted or the
+// container filesystem is torn down, which would otherwise cause bursts
+// of spurious errors. For example:
+//
+//   ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+//   defer cancel()
+//   gc.StopBefore(ctx)
+//   srv.Shutdown(ctx)
+//
+// The interrupted collection returns ErrStopped, and so do collections run
+// by Collect until the collector is started again.
+func (gc *GC) StopBefore(ctx context.Context) error {
+	atomic.StoreInt32(&gc.interrupted, 1)
+	stopped := make(chan struct{})
+	go func() {
+		gc.StopAndWait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// closedChan is a closed channel returned by Done if the collector
+// was never started.
+var closedChan = make(chan struct{})
+
+func init() { close(closedChan) }
+
+// Done returns a channel that is closed when the collector goroutine
+// started by the last call to Start exits, which happens after Stop once
+// the in-progress collection, if any, finishes. If the collector was
+// never started, the returned channel is closed.
+//
+// It allows supervisors to wait for the collector during shutdown:
+//
+//   gc.Stop()
+//   select {
+//   case <-gc.Done():
+//   case <-time.After(5 * time.Second):
+//           log.Print("session collector is still running")
+//   }
+//
+// Unlike most methods, it doesn't wait for the in-progress collection.
+func (gc *GC) Done() <-chan struct{} {
+	if done, ok := gc.exited.Load().(chan struct{}); ok {
+		return done
+	}
+	return closedChan
+}
+
+// Wait waits until the collector goroutine exits after Stop.
+// See Done.
+func (gc *GC) Wait() {
+	<-gc.Done()
+}
+
+// stopLocked stops the garbage collector. It must be called with
+// the lock held.
+func (gc *GC) stopLocked() {
 	if gc.ticker == nil {
 		return // not started
 	}
 	gc.ticker.Stop()
 	gc.ticker = nil
+	gc.reschedule = nil
+	close(gc.stop)
+	gc.stop = nil
+	gc.done = nil
+	atomic.StoreInt64(&gc.stats.started, 0)
+	atomic.StoreInt64(&gc.stats.nextRun, 0)
 }
 
 // Collect runs the garbage collection immediately.
 func (gc *GC) Collect() error {
+	_, err := gc.CollectReport()
+	return err
+}
+
+// CollectReport runs the garbage collection immediately and returns
+// the report describing its results.
+//
+// In multi-tenant mode, if collection fails for some tenant directories,
+// the collector continues with other tenants and returns the first error.
+func (gc *GC) CollectReport() (Report, error) {
+	return gc.CollectReportContext(context.Background())
+}
+
+// CollectContext runs the garbage collection immediately like Collect.
+// If the context is canceled or its deadline passes, the collection
+// stops and returns the context error.
+func (gc *GC) CollectContext(ctx context.Context) error {
+	_, err := gc.CollectReportContext(ctx)
+	return err
+}
+
+// CollectReportContext runs the garbage collection immediately like
+// CollectReport. If the context is canceled or its deadline passes,
+// the collection stops and returns the report describing what it has
+// done so far and the context error.
+func (gc *GC) CollectReportContext(ctx context.Context) (Report, error) {
+	return gc.collectReport(ctx, false)
+}
+
+// collectReport runs the garbage collection and calls hooks. Background
+// collections are skipped while the collector is paused.
+func (gc *GC) collectReport(ctx context.Context, background bool) (Report, error) {
+	gc.mu.Lock()
+	if background && gc.paused {
+		gc.mu.Unlock()
+		return Report{Skipped: true}, nil
+	}
+	onStart, onEnd := gc.onRunStart, gc.onRunEnd
+	onLag, lagThreshold := gc.onLag, gc.lagThreshold
+	onSpike, spikeThreshold := gc.onCreationSpike, gc.creationThreshold
+	gc.mu.Unlock()
+	if onStart != nil && !onStart() {
+		return Report{Skipped: true}, nil
+	}
+	r, err := gc.collect(ctx, background)
+	if r.Skipped {
+		return r, err
+	}
+	if onLag != nil && r.Lag > lagThreshold {
+		onLag(r.Lag)
+	}
+	if onSpike != nil && r.CreationRate > spikeThreshold {
+		onSpike(r.CreationRate)
+	}
+	if onEnd != nil {
+		onEnd(r)
+	}
+	return r, err
+}
+
+// collect runs the garbage collection and updates statistics.
+// Background collections are skipped if the collector was paused
+// while they were waiting for the lock.
+func (gc *GC) collect(ctx context.Context, background bool) (Report, error) {
 	gc.mu.Lock()
 	defer gc.mu.Unlock()
-	f, err := os.Open(gc.dir)
+	if background && gc.paused {
+		return Report{Skipped: true}, nil
+	}
+	gc.ctx = ctx
+	defer func() { gc.ctx = nil }()
+	if atomic.LoadInt32(&gc.interrupted) != 0 {
+		return Report{}, ErrStopped
+	}
+	start := time.Now()
+	runID := gc.startRun()
+	defer gc.finishRun()
+	if r, err := gc.checkFirstRun(gc.now()); err != nil {
+		r.RunID = runID
+		return r, err
+	}
+	gc.abort = nil
+	r, err := gc.sweep(gc.now())
+	gc.unpinDirs()
+	gc.closeProbe()
+	gc.flushDeleteGroup()
+	if err == nil && gc.canceled() {
+		err = gc.abort
+		if err == nil {
+			err = ctx.Err()
+		}
+		if err == nil {
+			err = ErrStopped
+		}
+	}
+	gc.finishIndex(err)
+	gc.expireTrash()
+	r.Lag = gc.lag
+	r.Lifetimes = gc.lifetimeStats()
+	r.Clamped = gc.isClamped()
+	gc.updatePressure(r, gc.leftLag)
+	r.Errors = gc.fileErrs
+	r.Reasons = gc.removedBy
+	gc.fileErrs = nil
+	gc.removedBy = nil
+	r.RunID = runID
+	r.Start = start
+	r.Duration = time.Since(start)
+	if err == nil {
+		gc.updateCreationRate(&r)
+		if !r.Anomaly {
+			gc.recordRemoved(r.Removed)
+		}
+	}
+	gc.record(r)
+	if gc.logger != nil {
+		if r.Clamped {
+			gc.logger.Printf("fsgc: run %s: max age limited to ceiling %s", runID, gc.ceiling)
+		}
+		for _, e := range r.ErrorSummary() {
+			gc.logger.Printf("fsgc: run %s: failed to remove %d session files: %v", runID, e.Count, e.Err)
+		}
+	}
+	if serr := gc.updateState(r); err == nil {
+		err = serr
+	}
+	gc.lastResult.Store(result{err})
+	return r, err
+}
+
+// sweep removes expired sessions from all session directories.
+//
+// It first scans all directories and then removes expired sessions,
+// starting with the directories that have the most of them, so that
+// if the run budget is exceeded, the most urgent directories are
+// already cleaned.
+func (gc *GC) sweep(now time.Time) (Report, error) {
+	var r Report
+	gc.nextExpiry = time.Time{}
+	gc.lag = 0
+	gc.leftLag = 0
+	gc.fileErrs = nil
+	gc.removedBy = nil
+	gc.trashRun = time.Now().UTC().Format(trashTimeFormat)
+	start := time.Now()
+	gc.startPacing()
+	gc.startIndex()
+	gc.detectMemoryFS()
+	gc.detectNoatime()
+	gc.startUnreadable()
+	var deadline time.Time
+	if gc.budget > 0 {
+		deadline = time.Now().Add(gc.budget)
+	}
+	if gc.strictMemory {
+		return gc.streamSweep(now, start, deadline)
+	}
+	if !gc.tenants && len(gc.extraDirs) == 0 {
+		s := gc.scanDir("", gc.dir, gc.coldDir, gc.policyFor(""), now)
+		if s.err == nil {
+			if r.Anomaly = gc.isAnomaly(len(s.expired)); !r.Anomaly {
+				gc.startProgress(start, len(s.expired))
+				r.BudgetExceeded = !gc.removeExpired(s, deadline)
+			} else {
+				gc.keepLag(s.expired...)
+			}
+			r.SelfCheck = gc.selfCheck([]*dirScan{s}, now)
+		}
+		r.Counts = s.counts
+		return r, s.err
+	}
+	dirs, err := gc.tenantDirs()
+	if err != nil {
+		return r, err
+	}
+	scans := make([]*dirScan, 0, len(dirs))
+	for _, d := range dirs {
+		if gc.canceled() {
+			break
+		}
+		s := gc.scanDir(d.tenant, d.path, d.coldDir, gc.policyFor(d.tenant), now)
+		scans = append(scans, s)
+	}
+	sort.SliceStable(scans, func(i, j int) bool {
+		if len(scans[i].expired) != len(scans[j].expired) {
+			return len(scans[i].expired) > len(scans[j].expired)
+		}
+		return scans[i].expiredSize > scans[j].expiredSize
+	})
+	total := 0
+	for _, s := range scans {
+		total += len(s.expired)
+	}
+	gc.startProgress(start, total)
+	r.Anomaly = gc.isAnomaly(total)
+	r.Tenants = make(map[string]Counts, len(dirs))
+	var firstErr error
+	for _, s := range scans {
+		if s.err != nil {
+			if firstErr == nil {
+				firstErr = s.err
+			}
+		} else if !r.BudgetExceeded && !r.Anomaly && !gc.canceled() {
+			r.BudgetExceeded = !gc.removeExpired(s, deadline)
+		} else {
+			gc.keepLag(s.expired...)
+		}
+		c := s.counts
+		r.Tenants[s.tenant] = c
+		r.Scanned += c.Scanned
+		r.Expired += c.Expired
+		r.Removed += c.Removed
+		r.Vanished += c.Vanished
+		r.InUse += c.InUse
+		r.Failed += c.Failed
+		r.Artifacts += c.Artifacts
+		r.Pinned += c.Pinned
+		r.Freed += c.Freed
+	}
+	r.SelfCheck = gc.selfCheck(scans, now)
+	return r, firstErr
+}
+
+// dirScan contains results of scanning a session directory.
+type dirScan struct {
+	tenant      string
+	policy      Policy
+	counts      Counts
+	expired     []sessionFile
+	expiredSize int64 // total size of expired session files
+	live        []sessionFile
+	err         error
+}
+
+// scanDir finds sessions expired according to the policy in dir
+// and moves stale sessions to coldDir, if it's not empty.
+func (gc *GC) scanDir(tenant, dir, coldDir string, p Policy, now time.Time) *dirScan {
+	s := &dirScan{tenant: tenant, policy: p}
+	if coldDir != "" {
+		// Scan cold storage first, so that sessions moved there
+		// during this collection are not examined twice.
+		fis, err := gc.readSessions(coldDir)
+		if err != nil && !os.IsNotExist(err) {
+			s.err = err
+			return s
+		}
+		for _, fi := range fis {
+			s.add(sessionFile{path: filepath.Join(coldDir, fi.Name()), fi: fi}, gc, now)
+		}
+	}
+	l, err := gc.readDir(dir)
+	if err != nil {
+		s.err = err
+		return s
+	}
+	fis := l.sessions
+	for _, u := range l.unreadable {
+		gc.handleUnreadable(s, dir, u, now)
+	}
+	removeOrphanSidecars(dir, fis, l.sidecars, now)
+	for _, fi := range l.artifacts {
+		if now.Sub(fi.ModTime()) > gc.artifactAge {
+			// Ignore errors.
+			if removeSession(filepath.Join(dir, fi.Name()), fi) == nil {
+				s.counts.Artifacts++
+			}
+		}
+	}
+	for _, fi := range fis {
+		f := sessionFile{path: filepath.Join(dir, fi.Name()), fi: fi}
+		if coldDir != "" && !gc.isExpired(p, fi, now) && now.Sub(fi.ModTime()) > gc.coldAfter {
+			// Session file is stale, move it to cold storage.
+			// Ignore errors.
+			// In recursive mode, the name includes the shard subdirectory.
+			coldName := filepath.Join(coldDir, fi.Name())
+			os.MkdirAll(filepath.Dir(coldName), 0700)
+			if os.Rename(f.path, coldName) == nil {
+				os.Rename(f.path+sidecarExt, coldName+sidecarExt)
+				f.path = coldName
+			}
+		}
+		s.add(f, gc, now)
+	}
+	if gc.userID != nil {
+		var extra []sessionFile
+		s.live, extra = gc.retainDaily(tenant, s.live, now)
+		for _, f := range extra {
+			s.counts.Expired++
+			s.expired = append(s.expired, f)
+			s.expiredSize += f.fi.Size()
+		}
+	}
+	return s
+}
+
+// add adds the session file to the scan results.
+func (s *dirScan) add(f sessionFile, gc *GC, now time.Time) {
+	s.counts.Scanned++
+	f.tenant = s.tenant
+	expires, pinned := gc.expiryTime(f.path, f.fi, s.policy)
+	f.reason, pinned = gc.reasonAt(f.path, f.fi, s.policy, expires, pinned, now)
+	if pinned {
+		s.counts.Pinned++
+		return
+	}
+	if f.reason != "" {
+		s.counts.Expired++
+		f.lag = now.Sub(f.fi.ModTime().Add(s.policy.MaxAge))
+		gc.updateLag(f.lag)
+		s.expired = append(s.expired, f)
+		s.expiredSize += f.fi.Size()
+		return
+	}
+	gc.updateNextExpiry(f.fi, s.policy, now)
+	gc.indexAdd(s.tenant, f, expires)
+	s.live = append(s.live, f)
+}
+
+// expiryReason returns the reason for removing the session file at the
+// given time, or an empty reason if the session is not expired.
+// Pinned session files are never expired, unless they are older than
+// the ceiling.
+func (gc *GC) expiryReason(path string, fi os.FileInfo, p Policy, now time.Time) (reason Reason, pinned bool) {
+	expires, pinned := gc.expiryTime(path, fi, p)
+	return gc.reasonAt(path, fi, p, expires, pinned, now)
+}
+
+// expiryTime returns the time after which the session file expires by age,
+// and whether it is pinned. For pinned session files, the time is when
+// they become older than the ceiling. The time is zero if it is not known,
+// because the function set by ExpireFunc decides whether the session is
+// expired, or if the pinned session never expires.
+func (gc *GC) expiryTime(path string, fi os.FileInfo, p Policy) (expires time.Time, pinned bool) {
+	var meta xattrMeta
+	if gc.xattrs {
+		meta = readXattrMeta(path)
+	}
+	switch {
+	case meta.pinned:
+		pinned = true
+	case !meta.expires.IsZero():
+		expires = meta.expires
+	default:
+		if d, ok := gc.ownMaxAge(path); ok {
+			expires = gc.lastUsed(fi).Add(d)
+		} else if gc.expireFunc == nil {
+			expires = gc.lastUsed(fi).Add(p.MaxAge)
+		}
+	}
+	if gc.ceiling > 0 && (pinned || !expires.IsZero()) {
+		if c := fi.ModTime().Add(gc.ceiling); expires.IsZero() || c.Before(expires) {
+			expires = c
+		}
+	}
+	return expires, pinned
+}
+
+// reasonAt returns the reason for removing the session file at the given
+// time, given its expiry time returned by expiryTime, like expiryReason.
+func (gc *GC) reasonAt(path string, fi os.FileInfo, p Policy, expires time.Time, pinned bool, now time.Time) (reason Reason, _ bool) {
+	switch {
+	case !expires.IsZero() && now.After(expires):
+		return ReasonAge, false
+	case pinned:
+		return "", true
+	case expires.IsZero() && gc.isExpired(p, fi, now):
+		reason = ReasonAge
+	}
+	if reason == "" && gc.maxLifetime > 0 && gc.isOverLifetime(path, now) {
+		reason = ReasonLifetime
+	}
+	return reason, false
+}
+
+// removeExpired removes expired sessions found by the scan and enforces
+// the policy quotas. It returns false if it stopped because the deadline
+// has passed. It also stops if the collection is canceled.
+func (gc *GC) removeExpired(s *dirScan, deadline time.Time) bool {
+	gc.startRetries()
+	defer gc.flushBatch()
+	if gc.verify {
+		defer gc.verifyRemoved(s)
+	}
+	defer gc.retryFailed(s)
+	sortSessions(s.expired, gc.order)
+	var open map[string]bool
+	if gc.skipOpen {
+		open = openFiles() // ignore errors
+	}
+	for i, f := range s.expired {
+		if gc.canceled() {
+			gc.keepLag(s.expired[i:]...)
+			return true
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			gc.keepLag(s.expired[i:]...)
+			return false
+		}
+		if open != nil && isOpen(open, f.path) {
+			s.counts.InUse++
+			gc.keepLag(f)
+			gc.advanceProgress(false)
+			continue
+		}
+		// Session file expired, delete it.
+		// Ignore errors.
+		gc.countRemoval(&s.counts, f, gc.discard(s.tenant, f))
+	}
+	if !gc.canceled() {
+		gc.enforceQuota(s.tenant, s.live, s.policy, &s.counts)
+	}
+	return true
+}
+
+// canceled reports whether the context of the current collection
+// is canceled, the collection was stopped by an error, or interrupted
+// by StopBefore.
+func (gc *GC) canceled() bool {
+	return gc.abort != nil || (gc.ctx != nil && gc.ctx.Err() != nil) ||
+		atomic.LoadInt32(&gc.interrupted) != 0
+}
+
+// countRemoval updates counts with the result of session file removal.
+//
+// Files that no longer exist were removed by the store or by another
+// collector, so they are counted as vanished rather than as failures.
+// Files that changed since they were examined are not counted.
+func (gc *GC) countRemoval(c *Counts, f sessionFile, err error) {
+	gc.advanceProgress(err == nil)
+	switch {
+	case err == nil:
+		c.Removed++
+		c.Freed += f.fi.Size()
+		if gc.removedBy == nil {
+			gc.removedBy = make(map[Reason]int)
+		}
+		gc.removedBy[f.reason]++
+		gc.batchRemoval(f.path)
+		gc.indexRemove(f)
+		gc.notifyDelete(f)
+		gc.recordLifetime(f)
+		if gc.verify && !gc.strictMemory {
+			gc.removedFiles = append(gc.removedFiles, f)
+		}
+	case os.IsNotExist(err):
+		c.Vanished++
+	case err != errChanged:
+		if gc.deferFailure(f) {
+			return
+		}
+		c.Failed++
+		gc.keepLag(f)
+		gc.fileErrs = append(gc.fileErrs, gc.redactError(err))
+	}
+}
+
+// errChanged is returned by removeSession if the session file has been
+// modified since it was examined.
+var errChanged = errors.New("fsgc: session file changed since scan")
+
+// removeSession removes the session file, unless its modification time
+// differs from the one in fi, which means that the session has been saved
+// after the file was examined and is no longer expired.
+func removeSession(name string, fi os.FileInfo) error {
+	if err := checkUnchanged(name, fi); err != nil {
+		return err
+	}
+	return os.Remove(name)
+}
+
+// checkUnchanged returns errChanged if the modification time of the file
+// differs from the one in fi.
+func checkUnchanged(name string, fi os.FileInfo) error {
+	cur, err := os.Lstat(name)
+	if _, ok := fi.(unreadableInfo); ok {
+		return checkUnreadable(err)
+	}
+	if err != nil {
+		return err
+	}
+	if !cur.ModTime().Equal(fi.ModTime()) {
+		return errChanged
+	}
+	return nil
+}
+
+// updateLag updates the sweep lag with the lag of an expired session file.
+func (gc *GC) updateLag(lag time.Duration) {
+	if lag > gc.lag {
+		gc.lag = lag
+	}
+}
+
+// keepLag updates the lag of expired session files left after the sweep
+// with the lags of the given files, which are not removed.
+func (gc *GC) keepLag(files ...sessionFile) {
+	for _, f := range files {
+		if f.lag > gc.leftLag {
+			gc.leftLag = f.lag
+		}
+	}
+}
+
+// updateNextExpiry updates the time of the next session expiration
+// with the expiration time of the given live session file.
+//
+// Sessions kept past the policy max age by SessionMaxAge or SetExpiry,
+// and sessions expired by the function set by ExpireFunc, have no known
+// expiration time and are left to scheduled collections.
+func (gc *GC) updateNextExpiry(fi os.FileInfo, p Policy, now time.Time) {
+	if gc.expireFunc != nil {
+		return
+	}
+	t := gc.lastUsed(fi).Add(p.MaxAge)
+	if !t.After(now) {
+		return
+	}
+	if gc.nextExpiry.IsZero() || t.Before(gc.nextExpiry) {
+		gc.nextExpiry = t
+	}
+}
+
+// readSessions returns information about session files in dir,
+// excluding files with skipped extensions.
+func (gc *GC) readSessions(dir string) ([]os.FileInfo, error) {
+	l, err := gc.readDir(dir)
+	return l.sessions, err
+}
+
+// dirListing describes files in a session directory.
+type dirListing struct {
+	sessions   []os.FileInfo // session files
+	artifacts  []os.FileInfo // store artifacts
+	sidecars   []os.FileInfo // creation time sidecars
+	unreadable []unreadable  // entries that couldn't be examined
+}
+
+// readDir returns information about session files, store artifacts,
+// and creation time sidecars in dir.
+//
+// Entries that couldn't be examined cause an error, unless the collector
+// is set to skip or remove them (see Unreadable), in which case they are
+// returned in the listing.
+func (gc *GC) readDir(dir string) (dirListing, error) {
+	var l dirListing
+	if err := gc.readDirInto(&l, dir, "", 0); err != nil {
+		return dirListing{}, err
+	}
+	return l, nil
+}
+
+// readDirInto adds files in the subdirectory rel of dir at the given depth
+// to the listing. In recursive mode, it also reads subdirectories, and
+// names of files in them are relative to dir.
+func (gc *GC) readDirInto(l *dirListing, dir, rel string, depth int) error {
+	f, err := os.Open(filepath.Join(dir, rel))
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	fis, err := f.Readdir(0)
+	names, err := f.Readdirnames(0)
+	f.Close()
 	if err != nil {
 		return err
 	}
-	now := time.Now()
-	for _, fi := range fis {
-		if fi.IsDir() || !strings.HasPrefix(fi.Name(), "session_") {
+	for _, name := range names {
+		relName := filepath.Join(rel, name)
+		fi, err := os.Lstat(filepath.Join(dir, relName))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // removed meanwhile
+			}
+			if gc.unreadable == AbortUnreadable {
+				return err
+			}
+			l.unreadable = append(l.unreadable, unreadable{relName, err})
 			continue
 		}
-		if now.Sub(fi.ModTime()) > gc.maxAge {
-			// Session file expired, delete it.
-			// Ignore errors.
-			os.Remove(filepath.Join(gc.dir, fi.Name()))
+		if fi.IsDir() {
+			if gc.recursive && depth < maxScanDepth && !gc.isSpecialDir(filepath.Join(dir, relName)) {
+				if err := gc.readDirInto(l, dir, relName, depth+1); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		if rel != "" {
+			fi = relFileInfo{fi, relName}
+		}
+		switch {
+		case isSidecar(name):
+			l.sidecars = append(l.sidecars, fi)
+		case gc.isArtifact(name):
+			l.artifacts = append(l.artifacts, fi)
+		case gc.isSession(name):
+			l.sessions = append(l.sessions, fi)
 		}
 	}
 	return nil
 }
+
+// isSession reports whether the file name is a name of session file.
+func (gc *GC) isSession(name string) bool {
+	if gc.match != nil {
+		if !gc.match(name) {
+			return false
+		}
+	} else if !strings.HasPrefix(name, sessionPrefix) {
+		return false
+	}
+	return !gc.hasSkippedExt(name) && !gc.isArtifact(name) && !isSidecar(name)
+}
+
+// isArtifact reports whether the file name is a name of store artifact.
+func (gc *GC) isArtifact(name string) bool {
+	if !strings.HasPrefix(name, sessionPrefix) {
+		return false
+	}
+	for _, ext := range gc.artifactExts {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
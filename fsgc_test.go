@@ -8,10 +8,21 @@
 package fsgc
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -52,3 +63,2789 @@ func TestGC(t *testing.T) {
 	}
 	os.RemoveAll(dir)
 }
+
+func TestWithNowFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	if err := ioutil.WriteFile(f1, []byte("session1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	gc := New(dir).MaxAge(time.Hour)
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(f1); err != nil {
+		t.Fatal(err)
+	}
+	gc.WithNowFunc(func() time.Time { return time.Now().Add(2 * time.Hour) })
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(f1); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: file %s exist, but should have been removed by GC", f1)
+	}
+}
+
+func TestExpiredAt(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	if err := ioutil.WriteFile(f1, []byte("session1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	gc := New(dir).MaxAge(time.Hour)
+	sessions, err := gc.ExpiredAt(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 0 {
+		t.Fatalf("fsgc: expected no expired sessions, got %d", len(sessions))
+	}
+	sessions, err = gc.ExpiredAt(time.Now().Add(2 * time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "1" || sessions[0].Path != f1 || sessions[0].Size != 8 {
+		t.Fatalf("fsgc: unexpected expired sessions: %+v", sessions)
+	}
+	if _, err := os.Lstat(f1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestColdDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cold := filepath.Join(dir, "cold")
+	if err := os.Mkdir(cold, 0700); err != nil {
+		t.Fatal(err)
+	}
+	f1 := filepath.Join(dir, "session_1")
+	if err := ioutil.WriteFile(f1, []byte("session1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(f1, time.Now(), time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	gc := New(dir).MaxAge(3*time.Hour).ColdDir(cold, time.Hour)
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(cold, "session_1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Restore("1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(f1); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	gc.WithNowFunc(func() time.Time { return time.Now().Add(2 * time.Hour) })
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(cold, "session_1")); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: cold session exist, but should have been removed by GC")
+	}
+}
+
+func TestPrecise(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	if err := ioutil.WriteFile(f1, []byte("session1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	gc := New(dir).MaxAge(200 * time.Millisecond).Interval(time.Hour).Precise(true).Start()
+	defer gc.Stop()
+	time.Sleep(600 * time.Millisecond)
+	if _, err := os.Lstat(f1); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: file %s exist, but should have been removed by GC", f1)
+	}
+}
+
+func TestAutoInterval(t *testing.T) {
+	tests := []struct {
+		maxAge, interval time.Duration
+	}{
+		{time.Second, time.Minute},
+		{12 * time.Hour, 72 * time.Minute},
+		{DefaultMaxAge, 6 * time.Hour},
+	}
+	for _, v := range tests {
+		gc := New("").MaxAge(v.maxAge).AutoInterval()
+		if d := gc.effectiveInterval(); d != v.interval {
+			t.Errorf("fsgc: interval for max age %s: expected %s, got %s", v.maxAge, v.interval, d)
+		}
+	}
+	gc := New("").AutoInterval().Interval(time.Second)
+	if d := gc.effectiveInterval(); d != time.Second {
+		t.Errorf("fsgc: expected interval %s, got %s", time.Second, d)
+	}
+}
+
+// writeSession writes a session file with the given age.
+func writeSession(t *testing.T, name string, age time.Duration) {
+	if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte("session"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(name, time.Now(), time.Now().Add(-age)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTenants(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "a", "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "a", "session_2"), 0)
+	writeSession(t, filepath.Join(dir, "b", "session_3"), 2*time.Hour)
+	r, err := New(dir).MaxAge(time.Hour).Tenants(true).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != 3 || r.Removed != 2 {
+		t.Fatalf("fsgc: unexpected report counts: %+v", r.Counts)
+	}
+	if c := r.Tenants["a"]; c.Scanned != 2 || c.Removed != 1 {
+		t.Fatalf("fsgc: unexpected counts for tenant a: %+v", c)
+	}
+	if c := r.Tenants["b"]; c.Scanned != 1 || c.Removed != 1 {
+		t.Fatalf("fsgc: unexpected counts for tenant b: %+v", c)
+	}
+}
+
+func TestTenantsTrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "a", "session_1"), 2*time.Hour)
+	gc := New(dir).MaxAge(time.Hour).Tenants(true).Trash(filepath.Join(dir, "trash"))
+	for i := 0; i < 2; i++ {
+		r, err := gc.CollectReport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := r.Tenants["trash"]; ok {
+			t.Fatalf("fsgc: trash collected as tenant: %+v", r.Tenants)
+		}
+	}
+	if n, err := gc.Undo(time.Time{}); err != nil || n != 1 {
+		t.Fatalf("fsgc: Undo restored %d sessions: %v", n, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "a", "session_1")); err != nil {
+		t.Fatalf("fsgc: session not restored: %v", err)
+	}
+}
+
+func TestTenantPolicy(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "a", "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "b", "session_2"), 2*time.Hour)
+	gc := New(dir).MaxAge(time.Hour).Tenants(true).TenantPolicy("b", Policy{MaxAge: 3 * time.Hour})
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Tenants["a"].Removed != 1 || r.Tenants["b"].Removed != 0 {
+		t.Fatalf("fsgc: unexpected per-tenant counts: %+v", r.Tenants)
+	}
+}
+
+func TestTenantQuota(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "a", "session_1"), 3*time.Minute)
+	writeSession(t, filepath.Join(dir, "a", "session_2"), 2*time.Minute)
+	writeSession(t, filepath.Join(dir, "a", "session_3"), 1*time.Minute)
+	gc := New(dir).Tenants(true).TenantPolicy("a", Policy{MaxSessions: 1})
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 || r.Reasons[ReasonCountQuota] != 2 {
+		t.Fatalf("fsgc: expected 2 sessions removed by count quota, got %+v", r)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "a", "session_3")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRemoveSessionChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	writeSession(t, f1, 2*time.Hour)
+	fi, err := os.Lstat(f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Session saved after scan.
+	if err := os.Chtimes(f1, time.Now(), time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeSession(f1, fi); err != errChanged {
+		t.Fatalf("fsgc: expected errChanged, got %v", err)
+	}
+	if _, err := os.Lstat(f1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 0)
+	sessions, err := New(dir).MaxAge(time.Hour).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 2 {
+		t.Fatalf("fsgc: expected 2 sessions, got %d", len(sessions))
+	}
+	for _, s := range sessions {
+		if s.Expired != (s.ID == "1") {
+			t.Errorf("fsgc: session %s: unexpected Expired = %v", s.ID, s.Expired)
+		}
+	}
+}
+
+func TestRunHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), DefaultMaxAge+time.Hour)
+	var events []string
+	var report Report
+	skip := false
+	gc := New(dir).OnRunStart(func() bool {
+		events = append(events, "start")
+		return !skip
+	}).OnRunEnd(func(r Report) {
+		events = append(events, "end")
+		report = r
+	})
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0] != "start" || events[1] != "end" {
+		t.Fatalf("fsgc: unexpected hook calls: %v", events)
+	}
+	if report.Removed != 1 {
+		t.Fatalf("fsgc: expected 1 removed session in report, got %d", report.Removed)
+	}
+	skip = true
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Skipped || len(events) != 3 {
+		t.Fatalf("fsgc: expected skipped collection, got %+v, hook calls: %v", r, events)
+	}
+}
+
+func TestSkipExtensions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1.lock")
+	writeSession(t, f1, DefaultMaxAge+time.Hour)
+	writeSession(t, filepath.Join(dir, "session_1"), DefaultMaxAge+time.Hour)
+	r, err := New(dir).SkipExtensions(".tmp", ".lock").CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != 1 || r.Removed != 1 {
+		t.Fatalf("fsgc: unexpected report counts: %+v", r.Counts)
+	}
+	if _, err := os.Lstat(f1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	state := filepath.Join(dir, "state.json")
+	writeSession(t, filepath.Join(dir, "session_1"), DefaultMaxAge+time.Hour)
+	if err := New(dir).StateFile(state).Collect(); err != nil {
+		t.Fatal(err)
+	}
+	st := New(dir).StateFile(state).Stats()
+	if st.Runs != 1 || st.Removed != 1 {
+		t.Fatalf("fsgc: unexpected stats loaded from state file: %+v", st)
+	}
+	// Corrupt state file.
+	if err := ioutil.WriteFile(state, []byte(`{"runs":`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	gc := New(dir).StateFile(state)
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if st := gc.Stats(); st.Runs != 1 || st.Removed != 0 {
+		t.Fatalf("fsgc: unexpected stats after corrupt state file: %+v", st)
+	}
+}
+
+func TestSortSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), time.Minute)
+	writeSession(t, filepath.Join(dir, "session_2"), time.Hour)
+	if err := ioutil.WriteFile(filepath.Join(dir, "session_1"), []byte("large session"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	var files []sessionFile
+	for _, name := range []string{"session_1", "session_2"} {
+		fi, err := os.Lstat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, sessionFile{path: name, fi: fi})
+	}
+	sortSessions(files, Oldest)
+	if files[0].path != "session_2" {
+		t.Errorf("fsgc: expected oldest session first, got %s", files[0].path)
+	}
+	sortSessions(files, Largest)
+	if files[0].path != "session_1" {
+		t.Errorf("fsgc: expected largest session first, got %s", files[0].path)
+	}
+}
+
+func TestLag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 3*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 2*time.Hour)
+	var lag time.Duration
+	gc := New(dir).MaxAge(time.Hour).OnLag(90*time.Minute, func(d time.Duration) {
+		lag = d
+	})
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Lag < 2*time.Hour || r.Lag > 2*time.Hour+time.Minute {
+		t.Fatalf("fsgc: unexpected lag %s", r.Lag)
+	}
+	if lag != r.Lag || gc.Lag() != r.Lag {
+		t.Fatalf("fsgc: OnLag got %s, Lag returned %s, expected %s", lag, gc.Lag(), r.Lag)
+	}
+}
+
+func TestCountRemovalVanished(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	writeSession(t, f1, time.Hour)
+	fi, err := os.Lstat(f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c Counts
+	gc := New(dir)
+	gc.countRemoval(&c, sessionFile{path: f1, fi: fi}, removeSession(f1, fi))
+	// Removed by someone else.
+	gc.countRemoval(&c, sessionFile{path: f1, fi: fi}, removeSession(f1, fi))
+	if c.Removed != 1 || c.Vanished != 1 {
+		t.Fatalf("fsgc: unexpected counts: %+v", c)
+	}
+}
+
+func TestSkipOpenFiles(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("open files detection is only supported on Linux")
+	}
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	writeSession(t, f1, DefaultMaxAge+time.Hour)
+	f, err := os.Open(f1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := New(dir).SkipOpenFiles(true).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.InUse != 1 || r.Removed != 0 {
+		t.Fatalf("fsgc: unexpected report counts: %+v", r.Counts)
+	}
+}
+
+func TestErrorSummary(t *testing.T) {
+	r := Report{Errors: []error{
+		&os.PathError{Op: "remove", Path: "session_1", Err: os.ErrPermission},
+		&os.PathError{Op: "remove", Path: "session_2", Err: os.ErrPermission},
+		&os.PathError{Op: "remove", Path: "session_3", Err: os.ErrInvalid},
+	}}
+	summary := r.ErrorSummary()
+	if len(summary) != 2 || summary[0].Count != 2 || summary[1].Count != 1 {
+		t.Fatalf("fsgc: unexpected error summary: %+v", summary)
+	}
+	if summary[0].Err != r.Errors[0] {
+		t.Fatalf("fsgc: expected first occurrence of error in summary, got %v", summary[0].Err)
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	c := New(dir).Config()
+	if err := c.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	c.MaxAge = 0
+	if err := c.Validate(); err == nil {
+		t.Fatal("fsgc: expected error for zero max age")
+	}
+	c = New(filepath.Join(dir, "missing")).Config()
+	if err := c.Validate(); err == nil {
+		t.Fatal("fsgc: expected error for missing directory")
+	}
+}
+
+func TestSimulateFromManifest(t *testing.T) {
+	now := time.Unix(1500000000, 0)
+	manifest := `# name mtime size
+session_1 1499990000.5 100
+session_2 1499999000 200
+session_3.lock 1400000000 10
+other 1400000000 10
+`
+	gc := New("/sessions").MaxAge(time.Hour).SkipExtensions(".lock").WithNowFunc(func() time.Time { return now })
+	sessions, err := gc.SimulateFromManifest(strings.NewReader(manifest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != "1" || sessions[0].Size != 100 || sessions[0].Path != "/sessions/session_1" {
+		t.Fatalf("fsgc: unexpected simulated sessions: %+v", sessions)
+	}
+	if _, err := gc.SimulateFromManifest(strings.NewReader("session_1 bad 100\n")); err == nil {
+		t.Fatal("fsgc: expected error for malformed manifest")
+	}
+}
+
+func TestSyncWithStore(t *testing.T) {
+	var mu sync.Mutex
+	storeMaxAge := 60
+	gc := New("").Interval(time.Hour).SyncWithStore(func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return storeMaxAge
+	}, 50*time.Millisecond).Start()
+	defer gc.Stop()
+	time.Sleep(20 * time.Millisecond)
+	if d := gc.Config().MaxAge; d != time.Minute {
+		t.Fatalf("fsgc: expected max age %s, got %s", time.Minute, d)
+	}
+	mu.Lock()
+	storeMaxAge = 120
+	mu.Unlock()
+	time.Sleep(100 * time.Millisecond)
+	if d := gc.Config().MaxAge; d != 2*time.Minute {
+		t.Fatalf("fsgc: expected max age %s, got %s", 2*time.Minute, d)
+	}
+}
+
+func TestDefaultCollector(t *testing.T) {
+	gc := Start("")
+	if defaultGC != gc {
+		t.Fatal("fsgc: Start didn't set the default collector")
+	}
+	Stop()
+	if defaultGC != nil {
+		t.Fatal("fsgc: Stop didn't reset the default collector")
+	}
+	Stop() // not started
+}
+
+// testGroup implements Group.
+type testGroup struct {
+	wg  sync.WaitGroup
+	err error
+}
+
+func (g *testGroup) Go(f func() error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.err = f()
+	}()
+}
+
+func TestGoFatal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var g testGroup
+	New(filepath.Join(dir, "missing")).Interval(10*time.Millisecond).FatalIf(os.IsNotExist).Go(context.Background(), &g)
+	g.wg.Wait()
+	if !os.IsNotExist(g.err) {
+		t.Fatalf("fsgc: expected not exist error, got %v", g.err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	g = testGroup{}
+	New(dir).Interval(10*time.Millisecond).FatalIf(os.IsNotExist).Go(ctx, &g)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	g.wg.Wait()
+	if g.err != nil {
+		t.Fatal(g.err)
+	}
+}
+
+func TestBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "a", "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "b", "session_2"), 2*time.Hour)
+	r, err := New(dir).MaxAge(time.Hour).Tenants(true).Budget(time.Nanosecond).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.BudgetExceeded || r.Removed != 0 || r.Scanned != 2 {
+		t.Fatalf("fsgc: unexpected report for exceeded budget: %+v", r)
+	}
+}
+
+func TestManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "a", "session_1")
+	f2 := filepath.Join(dir, "b", "session_2")
+	writeSession(t, f1, 2*time.Hour)
+	writeSession(t, f2, 2*time.Hour)
+	m := NewManager().Start()
+	defer m.Stop()
+	m.Add(New(filepath.Join(dir, "a")).MaxAge(time.Hour).Interval(50 * time.Millisecond))
+	m.Add(New(filepath.Join(dir, "b")).MaxAge(time.Hour).Interval(time.Hour))
+	time.Sleep(200 * time.Millisecond)
+	if _, err := os.Lstat(f1); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: file %s exist, but should have been removed by GC", f1)
+	}
+	if _, err := os.Lstat(f2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestManagerOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "sessions", "session_1"), 2*time.Hour)
+	// Moving sessions to trash fails, because it's not a directory.
+	trash := filepath.Join(dir, "trash")
+	if err := ioutil.WriteFile(trash, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	errs := make(chan error, 1)
+	onError := func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}
+	gc := New(filepath.Join(dir, "sessions")).MaxAge(time.Hour).Interval(10 * time.Millisecond).
+		Trash(trash).OnError(onError)
+	m := NewManager().Start()
+	defer m.Stop()
+	m.Add(gc)
+	select {
+	case <-errs:
+	case <-time.After(time.Second):
+		t.Fatal("fsgc: OnError not called for failed removal")
+	}
+}
+
+func TestArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1.tmp")
+	f2 := filepath.Join(dir, "session_2.tmp")
+	writeSession(t, f1, 10*time.Minute)
+	writeSession(t, f2, 0)
+	r, err := New(dir).SkipExtensions(".tmp").Artifacts(5*time.Minute, ".tmp").CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Artifacts != 1 || r.Scanned != 0 {
+		t.Fatalf("fsgc: unexpected report counts: %+v", r.Counts)
+	}
+	if _, err := os.Lstat(f1); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: file %s exist, but should have been removed by GC", f1)
+	}
+	if _, err := os.Lstat(f2); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCompare(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 3*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 90*time.Minute)
+	writeSession(t, filepath.Join(dir, "session_3"), 0)
+	onlyA, onlyB, err := New(dir).Compare(Policy{MaxAge: time.Hour}, Policy{MaxAge: 2 * time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onlyA) != 1 || onlyA[0].ID != "2" || len(onlyB) != 0 {
+		t.Fatalf("fsgc: unexpected comparison result: %+v, %+v", onlyA, onlyB)
+	}
+}
+
+func TestRetainDaily(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	now := time.Date(2015, 6, 10, 12, 0, 0, 0, time.Local)
+	sessions := []struct {
+		name string
+		mod  time.Time
+	}{
+		{"session_a1", now.Add(-time.Hour)},           // kept: recent
+		{"session_a2", now.Add(-2 * time.Hour)},       // kept: recent
+		{"session_a3", now.Add(-48 * time.Hour)},      // removed: older on the same day
+		{"session_a4", now.Add(-47 * time.Hour)},      // kept: newest for the day
+		{"session_b1", now.Add(-48 * time.Hour)},      // kept: another user
+		{"session_x1", now.Add(-50 * time.Hour)},      // kept: unknown user
+		{"session_a5", now.Add(-10 * 24 * time.Hour)}, // removed: expired
+	}
+	for _, s := range sessions {
+		name := filepath.Join(dir, s.name)
+		writeSession(t, name, 0)
+		if err := os.Chtimes(name, s.mod, s.mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+	userID := func(s SessionInfo) string {
+		if s.ID[0] == 'x' {
+			return ""
+		}
+		return s.ID[:1]
+	}
+	r, err := New(dir).WithNowFunc(func() time.Time { return now }).RetainDaily(24*time.Hour, userID).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 {
+		t.Fatalf("fsgc: expected 2 removed sessions, got %d", r.Removed)
+	}
+	for _, name := range []string{"session_a3", "session_a5"} {
+		if _, err := os.Lstat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("fsgc: file %s exist, but should have been removed by GC", name)
+		}
+	}
+}
+
+func TestRedactIDs(t *testing.T) {
+	gc := New("").RedactIDs(HashID)
+	err := gc.redactError(&os.PathError{Op: "remove", Path: "/sessions/session_secret", Err: os.ErrPermission})
+	if strings.Contains(err.Error(), "secret") {
+		t.Fatalf("fsgc: session ID not redacted: %v", err)
+	}
+	if p := err.(*os.PathError).Path; p != "/sessions/session_"+HashID("secret") {
+		t.Fatalf("fsgc: unexpected redacted path %s", p)
+	}
+}
+
+func TestTrashUndo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sessions := filepath.Join(dir, "sessions")
+	f1 := filepath.Join(sessions, "session_1")
+	writeSession(t, f1, 2*time.Hour)
+	if err := RecordCreation(f1); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().Add(-3 * time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(f1+sidecarExt, created, created); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	gc := New(sessions).MaxAge(time.Hour).Trash(filepath.Join(dir, "trash")).LifetimeAnalytics(true)
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(f1); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: file %s exist, but should have been moved to trash", f1)
+	}
+	if _, err := os.Lstat(f1 + sidecarExt); !os.IsNotExist(err) {
+		t.Fatal("fsgc: sidecar was not moved to trash")
+	}
+	if r.Lifetimes.Count != 1 {
+		t.Fatalf("fsgc: unexpected lifetimes: %+v", r.Lifetimes)
+	}
+	n, err := gc.Undo(start.Add(-time.Second))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Fatalf("fsgc: expected 1 restored session, got %d", n)
+	}
+	if _, err := os.Lstat(f1); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Lstat(f1 + sidecarExt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.ModTime().Equal(created) {
+		t.Fatalf("fsgc: restored creation time %v, expected %v", fi.ModTime(), created)
+	}
+}
+
+func TestTrashMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	trash := filepath.Join(dir, "trash")
+	old := filepath.Join(trash, time.Now().Add(-2*time.Hour).UTC().Format(trashTimeFormat))
+	writeSession(t, filepath.Join(old, "session_1"), 0)
+	recent := filepath.Join(trash, time.Now().UTC().Format(trashTimeFormat))
+	writeSession(t, filepath.Join(recent, "session_2"), 0)
+	if err := New(dir).Trash(trash).TrashMaxAge(time.Hour).Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(old); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: trash %s exist, but should have been removed by GC", old)
+	}
+	if _, err := os.Lstat(recent); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	writeSession(t, f1, 0)
+	if err := RecordCreation(f1); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(f1+sidecarExt, time.Now(), time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordCreation(f1); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(dir).MaxLifetime(time.Hour).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != 1 || r.Reasons[ReasonLifetime] != 1 {
+		t.Fatalf("fsgc: expected session removed by lifetime, got %+v", r)
+	}
+}
+
+func TestXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	f2 := filepath.Join(dir, "session_2")
+	writeSession(t, f1, 2*time.Hour)
+	writeSession(t, f2, 0)
+	if err := Pin(f1, true); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+	if err := SetExpiry(f2, time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(dir).MaxAge(time.Hour).Xattrs(true).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Pinned != 1 || r.Removed != 1 {
+		t.Fatalf("fsgc: unexpected report counts: %+v", r.Counts)
+	}
+	if _, err := os.Lstat(f2); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: file %s exist, but should have been removed by GC", f2)
+	}
+}
+
+func TestPressure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 0)
+	// Nonexistent directory to exclude disk usage.
+	gc := New(filepath.Join(dir, "missing"))
+	if p := gc.Pressure(); p != 0 {
+		t.Fatalf("fsgc: expected no pressure before collection, got %v", p)
+	}
+	gc.updatePressure(Report{Counts: Counts{Scanned: 4, Expired: 3}}, 0)
+	if p := gc.Pressure(); p != 0.75 {
+		t.Fatalf("fsgc: expected pressure 0.75, got %v", p)
+	}
+	gc.updatePressure(Report{Counts: Counts{Scanned: 4, Expired: 3, Removed: 2}}, 0)
+	if p := gc.Pressure(); p != 0.25 {
+		t.Fatalf("fsgc: expected pressure 0.25, got %v", p)
+	}
+	// The full sweep leaves no backlog.
+	gc = New(dir).MaxAge(time.Hour)
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 || r.Lag < time.Hour/2 {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+	want := 0.0
+	if usage, ok := diskUsage(dir); ok {
+		want = usage
+	}
+	if p := gc.Pressure(); p > want+0.01 {
+		t.Fatalf("fsgc: expected pressure %v after full sweep, got %v", want, p)
+	}
+}
+
+func TestPressureReconfigure(t *testing.T) {
+	gc := New(os.TempDir())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			gc.Pressure()
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		if err := gc.Reconfigure(func(c *Config) { c.Dir = os.TempDir() }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	<-done
+}
+
+func TestDenyNewSessions(t *testing.T) {
+	gc := New(filepath.Join(os.TempDir(), "fsgc-missing"))
+	gc.updatePressure(Report{Counts: Counts{Scanned: 10, Expired: 9}}, 0)
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := gc.DenyNewSessions(ok, "session", 0.8, nil)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("fsgc: expected new session denied, got status %d", w.Code)
+	}
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "x"})
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("fsgc: expected existing session allowed, got status %d", w.Code)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gc := New(dir).MaxAge(time.Hour).KeepHistory(2)
+	for i := 0; i < 3; i++ {
+		writeSession(t, filepath.Join(dir, "session_"+strings.Repeat("x", i+1)), 2*time.Hour)
+		if err := gc.Collect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := gc.History()
+	if len(h) != 2 {
+		t.Fatalf("fsgc: expected 2 reports in history, got %d", len(h))
+	}
+	if !h[0].Start.Before(h[1].Start) {
+		t.Fatalf("fsgc: history is not ordered: %v, %v", h[0].Start, h[1].Start)
+	}
+	if len(gc.KeepHistory(1).History()) != 1 {
+		t.Fatalf("fsgc: expected history to shrink to 1 report")
+	}
+}
+
+func TestWarmUp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 0)
+	gc := New(dir).MaxAge(time.Hour).Interval(time.Hour).KeepHistory(1).Index(true).WarmUp(true)
+	gc.Start()
+	defer gc.Stop()
+	var h []Report
+	for i := 0; i < 100 && len(h) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+		h = gc.History()
+	}
+	if len(h) != 1 || !h[0].WarmUp || h[0].Scanned != 2 || h[0].Expired != 1 {
+		t.Fatalf("fsgc: unexpected warm-up history: %+v", h)
+	}
+	if _, err := os.Lstat(name); err != nil {
+		t.Fatalf("fsgc: warm-up scan removed %s: %v", name, err)
+	}
+	if _, _, ok := gc.Lookup("2"); !ok || gc.Len() != 1 {
+		t.Fatalf("fsgc: warm-up scan didn't fill index with %d sessions", gc.Len())
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	valid := map[string]time.Duration{
+		"30m":     30 * time.Minute,
+		"12h":     12 * time.Hour,
+		"1d":      24 * time.Hour,
+		" 1.5d ":  36 * time.Hour,
+		"1w2d12h": 9*24*time.Hour + 12*time.Hour,
+		"0":       0,
+	}
+	for s, want := range valid {
+		d, err := ParseDuration(s)
+		if err != nil {
+			t.Errorf("fsgc: ParseDuration(%q): %v", s, err)
+		} else if d != want {
+			t.Errorf("fsgc: ParseDuration(%q) = %s, expected %s", s, d, want)
+		}
+	}
+	for _, s := range []string{"", "d", "-1d", "1x", "12", "1d2", "200000d", "106751d24h", "2562047h1d"} {
+		if _, err := ParseDuration(s); err == nil {
+			t.Errorf("fsgc: ParseDuration(%q): expected error", s)
+		}
+	}
+}
+
+func TestWouldBeCollected(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_OLD"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_NEW"), 0)
+	gc := New(dir).MaxAge(time.Hour)
+	decode := func(value string) (string, error) { return value, nil }
+	for id, expired := range map[string]bool{"OLD": true, "NEW": false} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.AddCookie(&http.Cookie{Name: "session", Value: id})
+		info, err := gc.WouldBeCollected(r, "session", decode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.ID != id || info.Expired != expired {
+			t.Fatalf("fsgc: unexpected session info: %+v", info)
+		}
+	}
+	r := httptest.NewRequest("GET", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "session", Value: "GONE"})
+	if _, err := gc.WouldBeCollected(r, "session", decode); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: expected not exist error, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	fi, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pretend the file was removed, but the removal silently failed.
+	gc := New(dir).Verify(true)
+	s := &dirScan{counts: Counts{Removed: 1}}
+	gc.removedBy = map[Reason]int{ReasonAge: 1}
+	gc.removedFiles = []sessionFile{{path: name, fi: fi, reason: ReasonAge}}
+	gc.verifyRemoved(s)
+	if s.counts.Removed != 1 || s.counts.Failed != 0 || gc.removedBy[ReasonAge] != 1 {
+		t.Fatalf("fsgc: unexpected counts after verification: %+v", s.counts)
+	}
+	if _, err := os.Lstat(name); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: file %s exist, but should have been removed by verification", name)
+	}
+
+	writeSession(t, name, 2*time.Hour)
+	r, err := gc.MaxAge(time.Hour).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 || r.Failed != 0 {
+		t.Fatalf("fsgc: unexpected report counts: %+v", r.Counts)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 3; i++ {
+		writeSession(t, filepath.Join(dir, "session_"+strings.Repeat("x", i+1)), 2*time.Hour)
+	}
+	var reports []Progress
+	gc := New(dir).MaxAge(time.Hour).OnProgress(time.Nanosecond, func(p Progress) {
+		reports = append(reports, p)
+	})
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if len(reports) != 3 {
+		t.Fatalf("fsgc: expected 3 progress reports, got %d", len(reports))
+	}
+	if last := reports[2]; last.Processed != 3 || last.Removed != 3 || last.Remaining != 0 {
+		t.Fatalf("fsgc: unexpected last progress report: %+v", last)
+	}
+}
+
+func TestStrictMemory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < strictBatch+10; i++ {
+		age := time.Duration(0)
+		if i%2 == 0 {
+			age = 2 * time.Hour
+		}
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), age)
+	}
+	r, err := New(dir).MaxAge(time.Hour).StrictMemory(true).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != strictBatch+10 || r.Removed != (strictBatch+10)/2 {
+		t.Fatalf("fsgc: unexpected report counts: %+v", r.Counts)
+	}
+	sessions, err := New(dir).List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != (strictBatch+10)/2 {
+		t.Fatalf("fsgc: expected %d sessions left, got %d", (strictBatch+10)/2, len(sessions))
+	}
+}
+
+func TestSyncBatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 5; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), 2*time.Hour)
+	}
+	gc := New(dir).MaxAge(time.Hour).SyncBatch(2)
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 5 {
+		t.Fatalf("fsgc: expected 5 removed sessions, got %d", r.Removed)
+	}
+	if gc.syncPending != 0 || len(gc.syncDirs) != 0 {
+		t.Fatalf("fsgc: removals left unsynced: %d", gc.syncPending)
+	}
+}
+
+func TestMaxRemovalRate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 3; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), 2*time.Hour)
+	}
+	start := time.Now()
+	if err := New(dir).MaxAge(time.Hour).MaxRemovalRate(20).Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if d := time.Since(start); d < 100*time.Millisecond {
+		t.Fatalf("fsgc: removed 3 sessions at 20/s in %s", d)
+	}
+}
+
+func TestParseIOMax(t *testing.T) {
+	data := []byte("8:0 rbps=max wbps=max riops=max wiops=max\n" +
+		"8:16 rbps=1048576 wbps=max riops=max wiops=120\n")
+	if n, ok := parseIOMax(data, 8, 16); !ok || n != 120 {
+		t.Fatalf("fsgc: expected limit 120, got %d, %v", n, ok)
+	}
+	if _, ok := parseIOMax(data, 8, 0); ok {
+		t.Fatalf("fsgc: expected no limit for max")
+	}
+	if _, ok := parseIOMax(data, 9, 0); ok {
+		t.Fatalf("fsgc: expected no limit for unknown device")
+	}
+}
+
+func TestCgroupLimit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("device names are not valid file names on Windows")
+	}
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(name, data string) {
+		name = filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(data), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Partition 8:1 of disk 8:0, as in /sys/dev/block.
+	write("devices/sda/dev", "8:0\n")
+	write("devices/sda/sda1/dev", "8:1\n")
+	write("devices/sda/sda1/partition", "1\n")
+	if err := os.MkdirAll(filepath.Join(dir, "dev"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../devices/sda/sda1", filepath.Join(dir, "dev", "8:1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../devices/sda", filepath.Join(dir, "dev", "8:0")); err != nil {
+		t.Fatal(err)
+	}
+	if major, minor := wholeDisk(filepath.Join(dir, "dev"), 8, 1); major != 8 || minor != 0 {
+		t.Fatalf("fsgc: expected disk 8:0 for partition, got %d:%d", major, minor)
+	}
+	if major, minor := wholeDisk(filepath.Join(dir, "dev"), 8, 0); major != 8 || minor != 0 {
+		t.Fatalf("fsgc: expected disk 8:0 for disk, got %d:%d", major, minor)
+	}
+	// The parent cgroup has a lower limit than the process cgroup.
+	write("cgroup/system.slice/io.max", "8:0 rbps=max wbps=max riops=max wiops=200\n")
+	write("cgroup/system.slice/app.service/io.max", "8:0 rbps=max wbps=max riops=max wiops=500\n")
+	n, ok := cgroupLimit(filepath.Join(dir, "cgroup"), "/system.slice/app.service", 8, 0)
+	if !ok || n != 200 {
+		t.Fatalf("fsgc: expected limit 200, got %d, %v", n, ok)
+	}
+	if _, ok := cgroupLimit(filepath.Join(dir, "cgroup"), "/system.slice/app.service", 8, 1); ok {
+		t.Fatalf("fsgc: expected no limit for partition")
+	}
+}
+
+func TestIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "acme", "session_OLD"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "acme", "session_NEW"), 0)
+	writeSession(t, filepath.Join(dir, "acme", "session_NEWER"), 0)
+	gc := New(dir).MaxAge(time.Hour).Tenants(true).Index(true).
+		TenantPolicy("acme", Policy{MaxSessions: 1})
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if n := gc.Len(); n != 1 {
+		t.Fatalf("fsgc: expected 1 session in index, got %d", n)
+	}
+	if _, _, ok := gc.Lookup("acme/OLD"); ok {
+		t.Fatalf("fsgc: expired session is in index")
+	}
+	info, expires, ok := gc.Lookup("acme/NEWER")
+	if !ok {
+		if _, _, ok = gc.Lookup("acme/NEW"); !ok {
+			t.Fatalf("fsgc: live session is not in index")
+		}
+	} else if info.Tenant != "acme" || expires.Before(time.Now()) {
+		t.Fatalf("fsgc: unexpected index entry: %+v, %v", info, expires)
+	}
+}
+
+func TestIndexExpiry(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("access times are only supported on Linux")
+	}
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if noatimeFS(dir) {
+		t.Skip("temporary directory is mounted with noatime")
+	}
+	name := filepath.Join(dir, "session_active")
+	writeSession(t, name, 0)
+	// Recently read, but modified long ago.
+	atime := time.Now().Add(-time.Minute).Truncate(time.Second)
+	if err := os.Chtimes(name, atime, time.Now().Add(-3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	gc := New(dir).MaxAge(time.Hour).Index(true).ExpireByAccess(true).
+		SessionMaxAge(func(data []byte) (time.Duration, bool) {
+			return 2 * time.Hour, true
+		})
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	_, expires, ok := gc.Lookup("active")
+	if !ok || !expires.Equal(atime.Add(2*time.Hour)) {
+		t.Fatalf("fsgc: expected expiry %v, got %v", atime.Add(2*time.Hour), expires)
+	}
+	gc = New(dir).Index(true).ExpireFunc(func(fi os.FileInfo, now time.Time) bool {
+		return false
+	})
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if _, expires, ok := gc.Lookup("active"); !ok || !expires.IsZero() {
+		t.Fatalf("fsgc: expected unknown expiry with ExpireFunc, got %v", expires)
+	}
+}
+
+func TestIndexAddDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	main, extra := filepath.Join(dir, "main"), filepath.Join(dir, "extra")
+	if err := os.MkdirAll(main, 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeSession(t, filepath.Join(extra, "session_OLD"), 10*time.Minute)
+	writeSession(t, filepath.Join(extra, "session_NEW"), 0)
+	gc := New(main).MaxAge(time.Hour).AddDir(extra).Index(true).
+		TenantPolicy(extra, Policy{MaxSessions: 1})
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if n := gc.Len(); n != 1 {
+		t.Fatalf("fsgc: expected 1 session in index, got %d", n)
+	}
+	if _, _, ok := gc.Lookup(path.Join(extra, "OLD")); ok {
+		t.Fatalf("fsgc: session removed by quota is in index")
+	}
+	if _, _, ok := gc.Lookup(path.Join(extra, "NEW")); !ok {
+		t.Fatalf("fsgc: live session is not in index")
+	}
+}
+
+func TestStartContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	gc := New(os.TempDir()).Interval(time.Hour).StartContext(ctx)
+	cancel()
+	for i := 0; i < 100; i++ {
+		gc.mu.Lock()
+		stopped := gc.ticker == nil
+		gc.mu.Unlock()
+		if stopped {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("fsgc: collector is not stopped after canceling context")
+}
+
+func TestFirstRunSafety(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_3"), 0)
+	confirmed := false
+	gc := New(dir).MaxAge(time.Hour).FirstRunSafety(0.5, func(scanned, expired int) bool {
+		if scanned != 3 || expired != 2 {
+			t.Errorf("fsgc: unexpected counts for confirmation: %d, %d", scanned, expired)
+		}
+		return confirmed
+	})
+	if err := gc.Collect(); err != ErrUnsafeFirstRun {
+		t.Fatalf("fsgc: expected ErrUnsafeFirstRun, got %v", err)
+	}
+	if sessions, _ := gc.List(); len(sessions) != 3 {
+		t.Fatalf("fsgc: refused collection removed sessions")
+	}
+	confirmed = true
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 {
+		t.Fatalf("fsgc: expected 2 removed sessions, got %d", r.Removed)
+	}
+}
+
+func TestRetryFailed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	fi, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc := New(dir).RetryFailed(2, time.Millisecond)
+	// Pretend the removal failed with a transient error.
+	f := sessionFile{path: name, fi: fi, reason: ReasonAge}
+	if !gc.deferFailure(f) {
+		t.Fatal("fsgc: failure is not deferred for retry")
+	}
+	s := &dirScan{}
+	gc.retryFailed(s)
+	if s.counts.Removed != 1 || s.counts.Failed != 0 {
+		t.Fatalf("fsgc: unexpected counts after retry: %+v", s.counts)
+	}
+	if _, err := os.Lstat(name); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: file %s exist, but should have been removed by retry", name)
+	}
+}
+
+func TestRetryFailedVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// A non-empty directory with a session name can't be removed.
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, filepath.Join(name, "session_2"), 0)
+	fi, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pretend the removal succeeded, so that verification finds it.
+	gc := New(dir).Verify(true).RetryFailed(2, time.Millisecond)
+	s := &dirScan{counts: Counts{Removed: 1}}
+	gc.removedBy = map[Reason]int{ReasonAge: 1}
+	gc.removedFiles = []sessionFile{{path: name, fi: fi, reason: ReasonAge}}
+	gc.removeExpired(s, time.Time{})
+	if s.counts.Removed != 0 || s.counts.Failed != 1 || len(gc.fileErrs) != 1 {
+		t.Fatalf("fsgc: unexpected counts after verification: %+v, errors: %v", s.counts, gc.fileErrs)
+	}
+	if len(gc.failedFiles) != 0 {
+		t.Fatalf("fsgc: failed files left for retry: %d", len(gc.failedFiles))
+	}
+}
+
+func TestStopAndWait(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 5; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), 2*time.Hour)
+	}
+	// Precise mode collects immediately; the rate limit makes
+	// the collection take about 40 ms.
+	gc := New(dir).MaxAge(time.Hour).Precise(true).MaxRemovalRate(100).Start()
+	time.Sleep(10 * time.Millisecond)
+	gc.StopAndWait()
+	if st := gc.Stats(); st.Runs != 1 || st.Removed != 5 {
+		t.Fatalf("fsgc: StopAndWait returned before collection finished: %+v", st)
+	}
+}
+
+func TestCollectContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 5; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), 2*time.Hour)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	gc := New(dir).MaxAge(time.Hour).OnProgress(time.Nanosecond, func(p Progress) {
+		if p.Processed == 2 {
+			cancel()
+		}
+	})
+	r, err := gc.CollectReportContext(ctx)
+	if err != context.Canceled {
+		t.Fatalf("fsgc: expected context.Canceled, got %v", err)
+	}
+	if r.Removed != 2 {
+		t.Fatalf("fsgc: expected 2 removed sessions before cancellation, got %d", r.Removed)
+	}
+}
+
+func TestMemoryFSPolicy(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("memory-backed filesystems are only detected on Linux")
+	}
+	dir, err := ioutil.TempDir("/dev/shm", "fsgc")
+	if err != nil {
+		t.Skipf("no tmpfs: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if ok, _ := memoryFS(dir); !ok {
+		t.Skip("/dev/shm is not on tmpfs")
+	}
+	for i := 0; i < 3; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), time.Duration(i)*time.Minute)
+	}
+	r, err := New(dir).MaxAge(time.Hour).MemoryFSPolicy(Policy{MaxSessions: 1}).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 || r.Reasons[ReasonCountQuota] != 2 {
+		t.Fatalf("fsgc: expected 2 sessions removed by count quota, got %+v", r.Reasons)
+	}
+	// Without MemoryFSPolicy, no quotas are added.
+	gc := New(dir).MaxAge(time.Hour)
+	if p := gc.policyFor(""); p.MaxBytes != 0 || p.MaxSessions != 0 {
+		t.Fatalf("fsgc: unexpected memory filesystem quotas %+v", p)
+	}
+	// Quotas apply to added directories on memory-backed filesystem.
+	diskDir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(diskDir)
+	gc = New(diskDir).AddDir(dir).MemoryFSPolicy(Policy{})
+	if ok, _ := memoryFS(diskDir); !ok {
+		if p := gc.policyFor(""); p.MaxBytes != 0 {
+			t.Fatalf("fsgc: unexpected quota for %s: %+v", diskDir, p)
+		}
+	}
+	if p := gc.policyFor(dir); p.MaxBytes == 0 {
+		t.Fatalf("fsgc: expected quota for %s", dir)
+	}
+}
+
+func TestDirChanged(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sessDir := filepath.Join(dir, "sessions")
+	for i := 0; i < 10; i++ {
+		writeSession(t, filepath.Join(sessDir, fmt.Sprintf("session_%d", i)), 2*time.Hour)
+	}
+	gc := New(sessDir).MaxAge(time.Hour).OnProgress(time.Nanosecond, func(p Progress) {
+		if p.Processed == 1 {
+			// Replace the directory.
+			if err := os.Rename(sessDir, sessDir+".old"); err != nil {
+				t.Error(err)
+			}
+			if err := os.Mkdir(sessDir, 0700); err != nil {
+				t.Error(err)
+			}
+		}
+	})
+	r, err := gc.CollectReport()
+	if err != ErrDirChanged {
+		t.Fatalf("fsgc: expected ErrDirChanged, got %v", err)
+	}
+	if r.Removed != 1 {
+		t.Fatalf("fsgc: collection didn't stop right after directory changed: %d removed", r.Removed)
+	}
+}
+
+func TestUnlinkRenamedDir(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("removal relative to open directory is only supported on Linux")
+	}
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sessDir := filepath.Join(dir, "sessions")
+	name := filepath.Join(sessDir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	fi, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gc := New(sessDir)
+	d, err := gc.pinDir(sessDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer gc.unpinDirs()
+	// Replace the directory after it was pinned.
+	if err := os.Rename(sessDir, sessDir+".old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(sessDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	// The file must be examined in the pinned directory, where it is
+	// removed, not in the new one.
+	if err := checkUnchangedAt(d.f, sessDir, "session_1", fi); err != nil {
+		t.Fatalf("fsgc: file in pinned directory not found: %v", err)
+	}
+	if err := unlinkAt(d.f, sessDir, "session_1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(sessDir+".old", "session_1")); !os.IsNotExist(err) {
+		t.Fatal("fsgc: file not removed from pinned directory")
+	}
+}
+
+func TestReportEncoders(t *testing.T) {
+	r := Report{
+		Counts:   Counts{Scanned: 3, Expired: 2, Removed: 2},
+		Start:    time.Date(2015, 1, 2, 3, 4, 5, 0, time.UTC),
+		Duration: 1500 * time.Millisecond,
+		Reasons:  map[Reason]int{ReasonAge: 2},
+		Tenants:  map[string]Counts{"acme": {Scanned: 3}},
+	}
+	var b bytes.Buffer
+	if err := (JSONReportEncoder{}).Encode(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`"version":1`, `"start":"2015-01-02T03:04:05Z"`, `"duration":1.5`,
+		`"removed":2`, `"reasons":{"age":2}`, `"tenants":{"acme":{"scanned":3`} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("fsgc: JSON report %s doesn't contain %s", b.String(), s)
+		}
+	}
+	b.Reset()
+	if err := (LogfmtReportEncoder{}).Encode(&b, r); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"version=1 start=2015-01-02T03:04:05Z duration=1.5 scanned=3",
+		" reasons.age=2 ", " tenants.acme.scanned=3 ", "\n"} {
+		if !strings.Contains(b.String(), s) {
+			t.Errorf("fsgc: logfmt report %q doesn't contain %q", b.String(), s)
+		}
+	}
+}
+
+func TestCollectOnStart(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	gc := New(dir).MaxAge(time.Hour).Interval(time.Hour).CollectOnStart(true).Start()
+	defer gc.Stop()
+	for i := 0; i < 100; i++ {
+		if _, err := os.Lstat(name); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("fsgc: file %s exist, but should have been removed on start", name)
+}
+
+func TestJitter(t *testing.T) {
+	gc := New(os.TempDir())
+	if d := gc.jitterDelay(); d != 0 {
+		t.Fatalf("fsgc: expected no delay without jitter, got %s", d)
+	}
+	gc.Jitter(10 * time.Millisecond)
+	for i := 0; i < 100; i++ {
+		if d := gc.jitterDelay(); d < 0 || d >= 20*time.Millisecond {
+			t.Fatalf("fsgc: delay %s is out of range", d)
+		}
+	}
+}
+
+func TestStatsJSON(t *testing.T) {
+	st := Stats{Runs: 2, Removed: 1}
+	b, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"version":1,"runs":2`) {
+		t.Fatalf("fsgc: unexpected stats JSON: %s", b)
+	}
+	var st2 Stats
+	if err := json.Unmarshal(b, &st2); err != nil {
+		t.Fatal(err)
+	}
+	if st2 != st {
+		t.Fatalf("fsgc: stats changed after JSON round trip: %+v", st2)
+	}
+}
+
+func TestCreationRate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 0)
+	var spike float64
+	gc := New(dir).MaxAge(time.Hour).OnCreationSpike(1, func(rate float64) { spike = rate })
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	writeSession(t, filepath.Join(dir, "session_3"), 0)
+	writeSession(t, filepath.Join(dir, "session_4"), 0)
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Created != 2 || r.CreationRate <= 1 {
+		t.Fatalf("fsgc: unexpected creation estimate: %d, %v", r.Created, r.CreationRate)
+	}
+	if spike != r.CreationRate || gc.CreationRate() != r.CreationRate {
+		t.Fatalf("fsgc: creation spike is not reported")
+	}
+}
+
+func TestAnomalyCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gc := New(dir).MaxAge(time.Hour).AnomalyCheck(5, nil)
+	for i := 0; i < anomalyMinRuns; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), 2*time.Hour)
+		if err := gc.Collect(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_x%d", i)), 2*time.Hour)
+	}
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.Anomaly || r.Removed != 0 || r.Expired != 10 {
+		t.Fatalf("fsgc: expected anomaly without removals, got %+v", r.Counts)
+	}
+	gc.AnomalyCheck(5, func(average float64, expired int) bool { return true })
+	if r, err = gc.CollectReport(); err != nil {
+		t.Fatal(err)
+	}
+	if r.Anomaly || r.Removed != 10 {
+		t.Fatalf("fsgc: expected confirmed removal, got %+v", r.Counts)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "sess_1.json"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "sess_2.txt"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_3"), 2*time.Hour)
+	r, err := New(dir).MaxAge(time.Hour).MatchGlob("sess_*.json").CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 {
+		t.Fatalf("fsgc: expected 1 removed session, got %d", r.Removed)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "sess_1.json")); !os.IsNotExist(err) {
+		t.Fatal("fsgc: file matching glob was not removed")
+	}
+	r, err = New(dir).MaxAge(time.Hour).MatchRegexp(regexp.MustCompile(`^sess_\d+\.txt$`)).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 {
+		t.Fatalf("fsgc: expected 1 removed session, got %d", r.Removed)
+	}
+	c := New(dir).MaxAge(time.Hour).Interval(time.Hour).MatchGlob("[").Config()
+	if err := c.Validate(); err == nil {
+		t.Fatal("fsgc: expected error for malformed glob")
+	}
+}
+
+func TestMatchFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_admin1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_user1"), 2*time.Hour)
+	r, err := New(dir).MaxAge(time.Hour).MatchFunc(func(name string) bool {
+		return strings.HasPrefix(name, "session_") && !strings.HasPrefix(name, "session_admin")
+	}).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != 1 || r.Removed != 1 {
+		t.Fatalf("fsgc: unexpected report counts: %+v", r.Counts)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "session_admin1")); err != nil {
+		t.Fatal("fsgc: session not matched by function was removed")
+	}
+}
+
+func TestRateSchedule(t *testing.T) {
+	gc := New(os.TempDir()).MaxRemovalRate(10).RateSchedule(
+		RateWindow{From: 22 * time.Hour, To: 6 * time.Hour, Rate: 0},
+		RateWindow{From: 9 * time.Hour, To: 18 * time.Hour, Rate: 50},
+	)
+	for _, tc := range []struct {
+		hour int
+		rate float64
+	}{{23, 0}, {3, 0}, {7, 10}, {12, 50}, {18, 10}} {
+		now := time.Date(2015, 1, 2, tc.hour, 0, 0, 0, time.Local)
+		if r := gc.currentRate(now); r != tc.rate {
+			t.Errorf("fsgc: rate at %d:00 is %v, expected %v", tc.hour, r, tc.rate)
+		}
+	}
+}
+
+func TestExpireFunc(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 0)
+	if err := ioutil.WriteFile(filepath.Join(dir, "session_2"), make([]byte, 100), 0600); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(dir).ExpireFunc(func(fi os.FileInfo, now time.Time) bool {
+		return fi.Size() > 10
+	}).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 {
+		t.Fatalf("fsgc: expected 1 removed session, got %d", r.Removed)
+	}
+	if _, err := os.Lstat(filepath.Join(dir, "session_2")); !os.IsNotExist(err) {
+		t.Fatal("fsgc: session expired by function was not removed")
+	}
+}
+
+func TestEnsureDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "a", "sessions")
+	if err := EnsureDir(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0700 {
+		t.Fatalf("fsgc: expected permissions 0700, got %v", fi.Mode().Perm())
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "file"), nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureDir(filepath.Join(dir, "file"), 0700); err == nil {
+		t.Fatal("fsgc: expected error for file")
+	}
+}
+
+func TestAudit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 0)
+	name := filepath.Join(dir, "session_2")
+	writeSession(t, name, 0)
+	if err := os.Chmod(name, 0644); err != nil {
+		t.Fatal(err)
+	}
+	issues, err := New(dir).Audit()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if len(issues) != 1 || issues[0].Session.Path != name {
+		t.Fatalf("fsgc: unexpected audit issues: %+v", issues)
+	}
+	if _, err := os.Lstat(name); err != nil {
+		t.Fatal("fsgc: audit removed session file")
+	}
+}
+
+func TestOnError(t *testing.T) {
+	errs := make(chan error, 1)
+	gc := New(filepath.Join(os.TempDir(), "fsgc-missing")).Interval(time.Hour).
+		CollectOnStart(true).OnError(func(err error) {
+		select {
+		case errs <- err:
+		default:
+		}
+	}).Start()
+	defer gc.Stop()
+	select {
+	case err := <-errs:
+		if !os.IsNotExist(err) {
+			t.Fatalf("fsgc: expected not exist error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fsgc: error of background collection was not reported")
+	}
+}
+
+func TestErrors(t *testing.T) {
+	gc := New(filepath.Join(os.TempDir(), "fsgc-missing")).Interval(time.Hour).CollectOnStart(true)
+	errs := gc.Errors()
+	gc.Start()
+	defer gc.Stop()
+	select {
+	case err := <-errs:
+		if !os.IsNotExist(err) {
+			t.Fatalf("fsgc: expected not exist error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fsgc: error of background collection was not received")
+	}
+}
+
+func TestOnDelete(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 0)
+	var deleted []string
+	err = New(dir).MaxAge(time.Hour).OnDelete(func(path string, fi os.FileInfo) {
+		deleted = append(deleted, path)
+	}).Collect()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != name {
+		t.Fatalf("fsgc: unexpected deleted files: %v", deleted)
+	}
+}
+
+func TestReportFreed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_3"), 0)
+	r, err := New(dir).MaxAge(time.Hour).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 || r.Freed != 2*int64(len("session")) || r.Duration <= 0 {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+}
+
+func TestUnreadable(t *testing.T) {
+	if runtime.GOOS == "windows" || os.Geteuid() == 0 {
+		t.Skip("permissions are not enforced")
+	}
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	// Without the search permission, names can be read, but not stat'ed.
+	if err := os.Chmod(dir, 0400); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chmod(dir, 0700)
+	if _, err := New(dir).MaxAge(time.Hour).CollectReport(); err == nil {
+		t.Fatal("fsgc: expected error")
+	}
+	r, err := New(dir).MaxAge(time.Hour).Unreadable(SkipUnreadable).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != 0 || len(r.Errors) != 1 {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+}
+
+func TestRemoveUnreadable(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 0)
+	gc := New(dir).MaxAge(time.Hour).Unreadable(RemoveUnreadable)
+	u := unreadable{"session_1", os.ErrPermission}
+	now := time.Now()
+	scan := func(now time.Time) *dirScan {
+		s := &dirScan{policy: gc.policyFor("")}
+		gc.fileErrs = nil
+		gc.startUnreadable()
+		gc.handleUnreadable(s, dir, u, now)
+		return s
+	}
+	// The directory mtime doesn't tell the age of the file.
+	if err := os.Chtimes(dir, now.Add(-2*time.Hour), now.Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if s := scan(now); len(s.expired) != 0 || len(gc.fileErrs) != 1 {
+		t.Fatalf("fsgc: unexpected scan of new unreadable file: %+v", s.counts)
+	}
+	s := scan(now.Add(2 * time.Hour))
+	if len(s.expired) != 1 || s.counts.Expired != 1 || len(gc.fileErrs) != 0 {
+		t.Fatalf("fsgc: expected unreadable file to expire: %+v", s.counts)
+	}
+	// The file is readable, so it's not removed until examined.
+	if err := gc.discard("", s.expired[0]); err != errChanged {
+		t.Fatalf("fsgc: expected errChanged, got %v", err)
+	}
+	if _, err := os.Stat(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(name); err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.discard("", s.expired[0]); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: expected not exist error, got %v", err)
+	}
+	// Files that are no longer unreadable are forgotten.
+	gc.startUnreadable()
+	gc.startUnreadable()
+	if s := scan(now.Add(4 * time.Hour)); len(s.expired) != 0 {
+		t.Fatal("fsgc: unreadable time not reset")
+	}
+}
+
+func TestRunID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	gc := New(dir).MaxAge(time.Hour)
+	var deleteID string
+	gc.OnDelete(func(path string, fi os.FileInfo) {
+		deleteID = gc.RunID()
+	})
+	r1, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r1.RunID == "" || deleteID != r1.RunID {
+		t.Fatalf("fsgc: expected run ID %q in OnDelete, got %q", r1.RunID, deleteID)
+	}
+	if id := gc.RunID(); id != "" {
+		t.Fatalf("fsgc: unexpected run ID %q after collection", id)
+	}
+	r2, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r2.RunID == "" || r2.RunID == r1.RunID {
+		t.Fatalf("fsgc: expected unique run IDs, got %q and %q", r1.RunID, r2.RunID)
+	}
+}
+
+func TestEnforceCeiling(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 3*time.Hour)
+	writeSession(t, filepath.Join(dir, "session_2"), time.Hour)
+	r, err := New(dir).MaxAge(24 * time.Hour).EnforceCeiling(2 * time.Hour).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 || !r.Clamped {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+}
+
+func TestEnforceCeilingXattrs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	f1 := filepath.Join(dir, "session_1")
+	f2 := filepath.Join(dir, "session_2")
+	writeSession(t, f1, 3*time.Hour)
+	writeSession(t, f2, 3*time.Hour)
+	if err := Pin(f1, true); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+	if err := SetExpiry(f2, time.Now().Add(24*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(dir).MaxAge(time.Hour).Xattrs(true).EnforceCeiling(2 * time.Hour).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 || r.Pinned != 0 {
+		t.Fatalf("fsgc: sessions outlived the ceiling: %+v", r.Counts)
+	}
+}
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gc := New(dir).Interval(time.Hour)
+	if gc.Running() {
+		t.Fatal("fsgc: running before start")
+	}
+	gc.Start()
+	st := gc.State()
+	if !st.Running || st.Started.IsZero() || st.Collecting {
+		t.Fatalf("fsgc: unexpected state after start: %+v", st)
+	}
+	gc.Stop()
+	if gc.Running() {
+		t.Fatal("fsgc: running after stop")
+	}
+}
+
+func TestLastRunNextRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gc := New(dir).Interval(time.Hour)
+	if !gc.LastRun().IsZero() || !gc.NextRun().IsZero() {
+		t.Fatal("fsgc: unexpected last or next run before start")
+	}
+	gc.Start()
+	defer gc.Stop()
+	deadline := time.Now().Add(time.Second)
+	for gc.NextRun().IsZero() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if d := gc.NextRun().Sub(time.Now()); d <= 59*time.Minute || d > time.Hour {
+		t.Fatalf("fsgc: unexpected next run in %s", d)
+	}
+	if err := gc.Collect(); err != nil || gc.LastResult() != nil {
+		t.Fatalf("fsgc: unexpected results %v, %v", err, gc.LastResult())
+	}
+	if gc.LastRun().IsZero() {
+		t.Fatal("fsgc: last run is not recorded")
+	}
+	gc = New(filepath.Join(dir, "missing"))
+	if err := gc.Collect(); err == nil || gc.LastResult() != err {
+		t.Fatalf("fsgc: expected last result %v, got %v", err, gc.LastResult())
+	}
+}
+
+func TestPause(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	gc := New(dir).MaxAge(time.Hour).Interval(10 * time.Millisecond)
+	gc.Pause()
+	gc.Start()
+	defer gc.Stop()
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("fsgc: session removed while paused: %v", err)
+	}
+	if !gc.Running() {
+		t.Fatal("fsgc: paused collector is not running")
+	}
+	gc.Resume()
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("fsgc: session not removed after resume")
+}
+
+func TestPauseManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	gc := New(dir).MaxAge(time.Hour).Interval(10 * time.Millisecond)
+	gc.Pause()
+	if r, _ := gc.collectBackground(); !r.Skipped {
+		t.Fatal("fsgc: background collection not skipped while paused")
+	}
+	m := NewManager().Start()
+	defer m.Stop()
+	m.Add(gc)
+	time.Sleep(50 * time.Millisecond)
+	if _, err := os.Stat(name); err != nil {
+		t.Fatalf("fsgc: session removed by manager while paused: %v", err)
+	}
+}
+
+func TestYieldOnLatency(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < latencyProbeEvery+1; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), 2*time.Hour)
+	}
+	r, err := New(dir).MaxAge(time.Hour).YieldOnLatency(time.Nanosecond).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != latencyProbeEvery+1 {
+		t.Fatalf("fsgc: expected %d removed, got %d", latencyProbeEvery+1, r.Removed)
+	}
+	if _, err := os.Stat(filepath.Join(dir, latencyProbeName)); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: probe file is not removed: %v", err)
+	}
+}
+
+func TestGroupDeletes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 20; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), 2*time.Hour)
+	}
+	var deleted int
+	var groups []DeleteGroup
+	r, err := New(dir).MaxAge(time.Hour).OnDelete(func(path string, fi os.FileInfo) {
+		deleted++
+	}).GroupDeletes(5, time.Hour, func(g DeleteGroup) {
+		groups = append(groups, g)
+	}).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 5 || len(groups) != 1 {
+		t.Fatalf("fsgc: expected 5 deletes and 1 group, got %d and %d", deleted, len(groups))
+	}
+	g := groups[0]
+	if g.Count != 15 || len(g.Samples) != deleteGroupSamples || g.RunID != r.RunID {
+		t.Fatalf("fsgc: unexpected group: %+v", g)
+	}
+}
+
+func TestIntervalWhileRunning(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	gc := New(dir).MaxAge(time.Hour).Interval(time.Hour).Start()
+	defer gc.Stop()
+	gc.Interval(10 * time.Millisecond)
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if _, err := os.Stat(name); os.IsNotExist(err) {
+			return
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	t.Fatal("fsgc: new interval is not applied to the started collector")
+}
+
+// fakeClock is a clock with manually set time and ticks.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+	c   chan time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) NewTicker(d time.Duration) Ticker { return c }
+func (c *fakeClock) C() <-chan time.Time              { return c.c }
+func (c *fakeClock) Stop()                            {}
+
+// advance moves the clock forward and delivers a tick.
+func (c *fakeClock) advance(d time.Duration) {
+	c.mu.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	c.mu.Unlock()
+	c.c <- now
+}
+
+func TestWithClock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 0)
+	clock := &fakeClock{now: time.Now(), c: make(chan time.Time)}
+	runs := make(chan Report, 1)
+	gc := New(dir).MaxAge(time.Hour).Interval(time.Minute).WithClock(clock).OnRunEnd(func(r Report) {
+		runs <- r
+	}).Start()
+	defer gc.Stop()
+	clock.advance(time.Minute)
+	if r := <-runs; r.Removed != 0 {
+		t.Fatalf("fsgc: removed %d sessions before expiry", r.Removed)
+	}
+	clock.advance(2 * time.Hour)
+	if r := <-runs; r.Removed != 1 {
+		t.Fatalf("fsgc: expected 1 removed session after expiry, got %d", r.Removed)
+	}
+}
+
+func TestSelfCheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for i := 0; i < 10; i++ {
+		writeSession(t, filepath.Join(dir, fmt.Sprintf("session_%d", i)), time.Duration(i)*time.Minute)
+	}
+	writeSession(t, filepath.Join(dir, "session_old"), 2*time.Hour)
+	r, err := New(dir).MaxAge(time.Hour).SelfCheck(5).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 || r.SelfCheck.Sampled != 5 || len(r.SelfCheck.Failed) != 0 {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+
+	// Pretend an expired session was kept.
+	name := filepath.Join(dir, "session_secret")
+	writeSession(t, name, 2*time.Hour)
+	fi, err := os.Lstat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &dirScan{policy: Policy{MaxAge: time.Hour}, live: []sessionFile{{path: name, fi: fi}}}
+	c := New(dir).SelfCheck(1).RedactIDs(HashID).selfCheck([]*dirScan{s}, time.Now())
+	if len(c.Failed) != 1 {
+		t.Fatalf("fsgc: unexpected self-check: %+v", c)
+	}
+	if f := c.Failed[0]; f.ID != HashID("secret") || strings.Contains(f.Path, "secret") {
+		t.Fatalf("fsgc: session ID not redacted: %+v", f)
+	}
+}
+
+func TestStopBefore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	gc := New(dir).MaxAge(time.Hour).Interval(time.Hour).Start()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := gc.StopBefore(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if gc.Running() {
+		t.Fatal("fsgc: running after StopBefore")
+	}
+	if err := gc.Collect(); err != ErrStopped {
+		t.Fatalf("fsgc: expected ErrStopped, got %v", err)
+	}
+	gc.Start()
+	defer gc.Stop()
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	main, extra := filepath.Join(dir, "main"), filepath.Join(dir, "extra")
+	writeSession(t, filepath.Join(main, "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(extra, "session_1"), 2*time.Hour)
+	writeSession(t, filepath.Join(extra, "session_2"), 0)
+	gc := New(main).MaxAge(time.Hour).AddDir(extra)
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 2 || r.Tenants[""].Removed != 1 || r.Tenants[extra].Removed != 1 {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+	sessions, err := gc.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].Tenant != extra {
+		t.Fatalf("fsgc: unexpected sessions: %+v", sessions)
+	}
+}
+
+func TestFreezeReconfigure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	gc := New(dir).MaxAge(time.Hour).Freeze()
+	func() {
+		defer func() {
+			if r := recover(); r != ErrFrozen {
+				t.Fatalf("fsgc: expected setter of frozen collector to panic with ErrFrozen, got %v", r)
+			}
+		}()
+		gc.MaxAge(2 * time.Hour)
+	}()
+	if c := gc.Config(); c.MaxAge != time.Hour {
+		t.Fatal("fsgc: setter changed frozen configuration")
+	}
+	err = gc.Reconfigure(func(c *Config) {
+		c.MaxAge = 2 * time.Hour
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c := gc.Config(); c.MaxAge != 2*time.Hour {
+		t.Fatalf("fsgc: expected max age %s, got %s", 2*time.Hour, c.MaxAge)
+	}
+	err = gc.Reconfigure(func(c *Config) {
+		c.MaxAge = -time.Hour
+	})
+	if err == nil {
+		t.Fatal("fsgc: expected error for invalid configuration")
+	}
+	if c := gc.Config(); c.MaxAge != 2*time.Hour {
+		t.Fatalf("fsgc: invalid configuration applied: max age %s", c.MaxAge)
+	}
+}
+
+func TestRecursive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	old := filepath.Join(dir, "ab", "cd", "session_abcd1")
+	writeSession(t, old, 2*time.Hour)
+	writeSession(t, filepath.Join(dir, "ab", "cd", "session_abcd2"), 0)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	r, err := New(dir).MaxAge(time.Hour).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 {
+		t.Fatalf("fsgc: expected 1 removed session without recursion, got %d", r.Removed)
+	}
+	r, err = New(dir).MaxAge(time.Hour).Recursive(true).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Scanned != 2 || r.Removed != 1 {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Fatalf("fsgc: expired session in subdirectory is not removed: %v", err)
+	}
+}
+
+func TestRecursiveTrashColdDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sessDir := filepath.Join(dir, "sessions")
+	coldDir := filepath.Join(dir, "cold")
+	expired := filepath.Join(sessDir, "ab", "cd", "session_abcd1")
+	stale := filepath.Join(sessDir, "ab", "cd", "session_abcd2")
+	writeSession(t, expired, 2*time.Hour)
+	writeSession(t, stale, 40*time.Minute)
+	gc := New(sessDir).MaxAge(time.Hour).Recursive(true).
+		Trash(filepath.Join(dir, "trash")).ColdDir(coldDir, 30*time.Minute)
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 {
+		t.Fatalf("fsgc: unexpected report: %+v", r)
+	}
+	if _, err := os.Stat(filepath.Join(coldDir, "ab", "cd", "session_abcd2")); err != nil {
+		t.Fatalf("fsgc: stale session is not moved to cold storage: %v", err)
+	}
+	if n, err := gc.Undo(time.Time{}); err != nil || n != 1 {
+		t.Fatalf("fsgc: expected 1 restored session, got %d, %v", n, err)
+	}
+	if _, err := os.Stat(expired); err != nil {
+		t.Fatalf("fsgc: session is not restored into its subdirectory: %v", err)
+	}
+}
+
+func TestWallGap(t *testing.T) {
+	start := time.Now().UnixNano()
+	if gap := wallGap(start, start+int64(catchUpCheck), catchUpCheck); gap != 0 {
+		t.Errorf("fsgc: unexpected gap %s for regular check", gap)
+	}
+	if gap := wallGap(start, start+int64(catchUpCheck+time.Hour), catchUpCheck); gap != time.Hour {
+		t.Errorf("fsgc: expected gap %s after suspend, got %s", time.Hour, gap)
+	}
+}
+
+func TestReconfigureHooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "sess_1"), 2*time.Hour)
+	var deleted []string
+	gc := New(dir).MaxAge(24 * time.Hour)
+	err = gc.Reconfigure(func(c *Config) {
+		c.MaxAge = time.Hour
+		c.MatchGlob = "sess_*"
+		c.Hooks.OnDelete = func(path string, fi os.FileInfo) {
+			deleted = append(deleted, fi.Name())
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := gc.Collect(); err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "sess_1" {
+		t.Fatalf("fsgc: unexpected deleted files: %v", deleted)
+	}
+}
+
+func TestDone(t *testing.T) {
+	gc := New(os.TempDir()).Interval(time.Hour)
+	select {
+	case <-gc.Done():
+	default:
+		t.Fatal("fsgc: Done is not closed before start")
+	}
+	gc.Start()
+	done := gc.Done()
+	select {
+	case <-done:
+		t.Fatal("fsgc: Done is closed while running")
+	default:
+	}
+	gc.Stop()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("fsgc: Done is not closed after stop")
+	}
+	gc.Wait()
+}
+
+func TestSessionMaxAge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	remember := filepath.Join(dir, "session_remember")
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	writeSession(t, remember, 2*time.Hour)
+	if err := ioutil.WriteFile(remember, []byte("remember"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(remember, time.Now(), time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	gc := New(dir).MaxAge(time.Hour).SessionMaxAge(func(data []byte) (time.Duration, bool) {
+		if string(data) == "remember" {
+			return 30 * 24 * time.Hour, true
+		}
+		return 0, false
+	})
+	expired, err := gc.ExpiredAt(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expired) != 1 || expired[0].ID != "1" || expired[0].Reason != ReasonAge {
+		t.Fatalf("fsgc: unexpected expired sessions: %+v", expired)
+	}
+	onlyA, onlyB, err := gc.Compare(Policy{MaxAge: time.Minute}, Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onlyA) != 0 || len(onlyB) != 0 {
+		t.Fatalf("fsgc: unexpected comparison: %+v, %+v", onlyA, onlyB)
+	}
+	r, err := gc.CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 {
+		t.Fatalf("fsgc: expected 1 removed session, got %d", r.Removed)
+	}
+	if _, err := os.Stat(remember); err != nil {
+		t.Fatalf("fsgc: session with own max age removed: %v", err)
+	}
+}
+
+func TestSessionMaxAgePrecise(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeSession(t, filepath.Join(dir, "session_1"), 2*time.Hour)
+	var runs int32
+	gc := New(dir).MaxAge(time.Hour).Interval(time.Hour).Precise(true).
+		SessionMaxAge(func(data []byte) (time.Duration, bool) {
+			return 30 * 24 * time.Hour, true
+		}).
+		OnRunEnd(func(Report) { atomic.AddInt32(&runs, 1) }).
+		Start()
+	time.Sleep(300 * time.Millisecond)
+	gc.StopAndWait()
+	// The session is kept by its own max age, so precise mode must not
+	// schedule collections for the time it would have expired.
+	if n := atomic.LoadInt32(&runs); n != 1 {
+		t.Fatalf("fsgc: expected 1 collection, got %d", n)
+	}
+}
+
+func TestLifetimeAnalytics(t *testing.T) {
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	if err := RecordCreation(name); err != nil {
+		t.Fatal(err)
+	}
+	created := time.Now().Add(-3 * time.Hour)
+	if err := os.Chtimes(name+sidecarExt, created, created); err != nil {
+		t.Fatal(err)
+	}
+	writeSession(t, filepath.Join(dir, "session_2"), 2*time.Hour)
+	r, err := New(dir).MaxAge(time.Hour).LifetimeAnalytics(true).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := r.Lifetimes
+	if l.Count != 1 || l.Unknown != 1 || l.P50 < 3*time.Hour || l.P50 > 3*time.Hour+time.Minute || l.Max != l.P50 {
+		t.Fatalf("fsgc: unexpected lifetimes: %+v", l)
+	}
+}
+
+func TestExpireByAccess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("access times are only supported on Linux")
+	}
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if noatimeFS(dir) {
+		t.Skip("temporary directory is mounted with noatime")
+	}
+	name := filepath.Join(dir, "session_1")
+	writeSession(t, name, 2*time.Hour)
+	// Recently read, but modified long ago.
+	if err := os.Chtimes(name, time.Now(), time.Now().Add(-2*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(dir).MaxAge(time.Hour).ExpireByAccess(true).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 0 {
+		t.Fatal("fsgc: recently accessed session removed")
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(name, old, old); err != nil {
+		t.Fatal(err)
+	}
+	r, err = New(dir).MaxAge(time.Hour).ExpireByAccess(true).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 1 {
+		t.Fatal("fsgc: idle session is not removed")
+	}
+}
+
+func TestExpireByAccessSessionMaxAge(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("access times are only supported on Linux")
+	}
+	dir, err := ioutil.TempDir("", "fsgc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if noatimeFS(dir) {
+		t.Skip("temporary directory is mounted with noatime")
+	}
+	active := filepath.Join(dir, "session_active")
+	idle := filepath.Join(dir, "session_idle")
+	writeSession(t, active, 3*time.Hour)
+	writeSession(t, idle, 100*time.Minute)
+	// Recently read, but modified long ago.
+	if err := os.Chtimes(active, time.Now(), time.Now().Add(-3*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	// Last read before the last modification, so that relatime would
+	// update the access time on read.
+	atime := time.Now().Add(-110 * time.Minute)
+	if err := os.Chtimes(idle, atime, time.Now().Add(-100*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	r, err := New(dir).MaxAge(time.Hour).ExpireByAccess(true).
+		SessionMaxAge(func(data []byte) (time.Duration, bool) {
+			return 2 * time.Hour, true
+		}).CollectReport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Removed != 0 {
+		t.Fatal("fsgc: recently accessed session with own max age removed")
+	}
+	fi, err := os.Lstat(idle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if at, _ := accessTime(fi); at.Unix() != atime.Unix() {
+		t.Fatalf("fsgc: reading session updated access time to %v", at)
+	}
+}
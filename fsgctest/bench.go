@@ -0,0 +1,83 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgctest
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/dchest/gorilla-fsgc"
+)
+
+// Populate creates n session files in dir, the given fraction of which,
+// from 0 to 1, are older than maxAge, and the rest are newer.
+func Populate(dir string, n int, expired float64, maxAge time.Duration) error {
+	now := time.Now()
+	nExpired := int(float64(n) * expired)
+	data := []byte("session")
+	for i := 0; i < n; i++ {
+		name := filepath.Join(dir, fmt.Sprintf("session_%08d", i))
+		if err := ioutil.WriteFile(name, data, 0600); err != nil {
+			return err
+		}
+		mtime := now.Add(-maxAge / 2)
+		if i < nExpired {
+			mtime = now.Add(-2 * maxAge)
+		}
+		if err := os.Chtimes(name, mtime, mtime); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// BenchResult is the result of Bench.
+type BenchResult struct {
+	Report   fsgc.Report   // report of the collection
+	Duration time.Duration // duration of the collection
+	Rate     float64       // removed session files per second
+}
+
+// Bench measures the throughput of a collection of n session files,
+// the given fraction of which are expired, on the hardware it runs on.
+// It generates the session files in a temporary subdirectory of dir,
+// or of the default directory for temporary files if dir is empty,
+// which is removed afterwards.
+//
+// If configure is not nil, it is called to configure the collector
+// before the collection, for example, to enable rate limits.
+//
+// The results help with choosing the interval between collections
+// and the budget: a collection must be able to remove the sessions
+// expired during the interval.
+func Bench(dir string, n int, expired float64, configure func(*fsgc.GC)) (BenchResult, error) {
+	const maxAge = time.Hour
+	var res BenchResult
+	tmp, err := ioutil.TempDir(dir, "fsgc-bench")
+	if err != nil {
+		return res, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := Populate(tmp, n, expired, maxAge); err != nil {
+		return res, err
+	}
+	gc := fsgc.New(tmp).MaxAge(maxAge)
+	if configure != nil {
+		configure(gc)
+	}
+	start := time.Now()
+	res.Report, err = gc.CollectReport()
+	res.Duration = time.Since(start)
+	if res.Duration > 0 {
+		res.Rate = float64(res.Report.Removed) / res.Duration.Seconds()
+	}
+	return res, err
+}
@@ -0,0 +1,118 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+// Package fsgctest provides a conformance test for session collectors.
+//
+// Authors of collectors for other storage backends can use it to check
+// that their collectors behave like fsgc.GC:
+//
+//   func TestConformance(t *testing.T) {
+//           fsgctest.TestCollector(t, func(t *testing.T, maxAge time.Duration) (fsgctest.Collector, fsgctest.Store) {
+//                   ...
+//           })
+//   }
+//
+package fsgctest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/dchest/gorilla-fsgc"
+)
+
+// Collector is a session collector under test.
+type Collector interface {
+	// CollectReportContext runs the collection and returns the report
+	// describing its results. If the context is canceled, it must stop
+	// and return the context error.
+	CollectReportContext(ctx context.Context) (fsgc.Report, error)
+}
+
+// Store creates and inspects sessions in the storage of the collector
+// under test.
+type Store interface {
+	// Create creates a session with the given ID last modified
+	// the given time ago.
+	Create(id string, age time.Duration) error
+
+	// Exists reports whether the session with the given ID exists.
+	Exists(id string) (bool, error)
+}
+
+// TestCollector tests expiry correctness, report accuracy, and
+// cancellation of collectors.
+//
+// The function newCollector must return a new collector with the given
+// max age and the store it collects, both empty.
+func TestCollector(t *testing.T, newCollector func(t *testing.T, maxAge time.Duration) (Collector, Store)) {
+	t.Run("Expiry", func(t *testing.T) {
+		c, s := newCollector(t, time.Hour)
+		create(t, s, "old", 3, 2*time.Hour)
+		create(t, s, "new", 2, time.Minute)
+		if _, err := c.CollectReportContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		check(t, s, "old", 3, false)
+		check(t, s, "new", 2, true)
+	})
+	t.Run("Report", func(t *testing.T) {
+		c, s := newCollector(t, time.Hour)
+		create(t, s, "old", 3, 2*time.Hour)
+		create(t, s, "new", 2, time.Minute)
+		r, err := c.CollectReportContext(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Scanned != 5 || r.Expired != 3 || r.Removed != 3 || r.Failed != 0 {
+			t.Fatalf("unexpected report counts: %+v", r.Counts)
+		}
+		if r, err = c.CollectReportContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if r.Scanned != 2 || r.Expired != 0 || r.Removed != 0 {
+			t.Fatalf("unexpected report counts of repeated collection: %+v", r.Counts)
+		}
+	})
+	t.Run("Cancel", func(t *testing.T) {
+		c, s := newCollector(t, time.Hour)
+		create(t, s, "old", 3, 2*time.Hour)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if _, err := c.CollectReportContext(ctx); err != context.Canceled {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		check(t, s, "old", 3, true)
+	})
+}
+
+// create creates n sessions with IDs starting with prefix.
+func create(t *testing.T, s Store, prefix string, n int, age time.Duration) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := s.Create(fmt.Sprintf("%s%d", prefix, i), age); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// check checks that n sessions with IDs starting with prefix exist or not.
+func check(t *testing.T, s Store, prefix string, n int, exist bool) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("%s%d", prefix, i)
+		ok, err := s.Exists(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok != exist {
+			t.Fatalf("session %s exists: %v, expected %v", id, ok, exist)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgctest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/dchest/gorilla-fsgc"
+)
+
+// dirStore is a Store of session files in a directory.
+type dirStore string
+
+func (d dirStore) Create(id string, age time.Duration) error {
+	name := filepath.Join(string(d), "session_"+id)
+	if err := ioutil.WriteFile(name, []byte("session"), 0600); err != nil {
+		return err
+	}
+	return os.Chtimes(name, time.Now(), time.Now().Add(-age))
+}
+
+func (d dirStore) Exists(id string) (bool, error) {
+	_, err := os.Lstat(filepath.Join(string(d), "session_"+id))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func TestGC(t *testing.T) {
+	TestCollector(t, func(t *testing.T, maxAge time.Duration) (Collector, Store) {
+		dir, err := ioutil.TempDir("", "fsgctest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.RemoveAll(dir) })
+		return fsgc.New(dir).MaxAge(maxAge), dirStore(dir)
+	})
+}
+
+func TestBench(t *testing.T) {
+	res, err := Bench("", 100, 0.25, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Report.Scanned != 100 || res.Report.Removed != 25 || res.Rate <= 0 {
+		t.Fatalf("unexpected result: %+v", res)
+	}
+}
@@ -0,0 +1,134 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"context"
+	"fmt"
+)
+
+// Group is a group of goroutines, such as errgroup.Group from
+// golang.org/x/sync/errgroup.
+type Group interface {
+	Go(f func() error)
+}
+
+// FatalIf sets the function deciding whether an error from a background
+// collection is fatal, and returns the same GC. See Go.
+func (gc *GC) FatalIf(f func(error) bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.fatalIf = f
+	return gc
+}
+
+// Go runs the collector in the group until the context is canceled
+// or a fatal error happens.
+//
+// The collector is started and, when the context is canceled, stopped,
+// and the group function returns nil. If a background collection fails
+// with an error for which the function set by FatalIf returns true,
+// the collector is stopped and the group function returns the error,
+// which for errgroup.Group cancels the group context, allowing the
+// whole service to go down intentionally. For example:
+//
+//   g, ctx := errgroup.WithContext(ctx)
+//   gc.FatalIf(os.IsPermission).Go(ctx, g)
+//
+func (gc *GC) Go(ctx context.Context, g Group) {
+	g.Go(func() error {
+		fatal := make(chan error, 1)
+		gc.mu.Lock()
+		gc.fatal = fatal
+		gc.mu.Unlock()
+		gc.Start()
+		defer func() {
+			gc.Stop()
+			gc.mu.Lock()
+			gc.fatal = nil
+			gc.mu.Unlock()
+		}()
+		select {
+		case <-ctx.Done():
+			return nil
+		case err := <-fatal:
+			return err
+		}
+	})
+}
+
+// StartContext starts the garbage collector like Start, and stops it when
+// the context is canceled, once the in-progress collection, if any,
+// finishes. It returns the same GC.
+//
+// If the collector is already started, the context stops it as well.
+// Stopping the collector with Stop before the context is canceled is
+// still allowed. Canceling the context after that doesn't affect
+// the collector restarted by Start.
+func (gc *GC) StartContext(ctx context.Context) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.startLocked()
+	stop := gc.stop
+	go func() {
+		select {
+		case <-ctx.Done():
+			gc.mu.Lock()
+			if gc.stop == stop {
+				gc.stopLocked()
+			}
+			gc.mu.Unlock()
+		case <-stop:
+		}
+	}()
+	return gc
+}
+
+// handleReport handles the result of a background collection.
+func (gc *GC) handleReport(r Report, err error) {
+	gc.handleError(err)
+	if err == nil && len(r.Errors) > 0 {
+		gc.notifyError(fmt.Errorf("fsgc: run %s: failed to remove %d session files: %v", r.RunID, r.Failed, r.Errors[0]))
+	}
+}
+
+// handleError handles an error from a background collection.
+func (gc *GC) handleError(err error) {
+	if err == nil {
+		return
+	}
+	gc.notifyError(err)
+	gc.mu.Lock()
+	fatalIf, fatal := gc.fatalIf, gc.fatal
+	gc.mu.Unlock()
+	if fatal != nil && fatalIf != nil && fatalIf(err) {
+		select {
+		case fatal <- err:
+		default:
+		}
+	}
+}
+
+// notifyError passes an error from a background collection to the
+// function set by OnError and to the channel returned by Errors.
+func (gc *GC) notifyError(err error) {
+	gc.mu.Lock()
+	onError, errs := gc.onError, gc.errs
+	gc.mu.Unlock()
+	if onError != nil {
+		onError(err)
+	}
+	if errs != nil {
+		select {
+		case errs <- err:
+		default:
+			// Nobody is receiving, drop the error.
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+// KeepHistory sets the number of reports of the last collections kept
+// in memory, and returns the same GC. By default, no history is kept.
+// See History.
+func (gc *GC) KeepHistory(n int) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	old := gc.historyLocked()
+	if n <= 0 {
+		gc.history = nil
+		gc.historyNext = 0
+		return gc
+	}
+	if len(old) > n {
+		old = old[len(old)-n:]
+	}
+	gc.history = make([]Report, len(old), n)
+	copy(gc.history, old)
+	gc.historyNext = len(old) % n
+	return gc
+}
+
+// History returns reports of the last collections, oldest first.
+// The number of kept reports is set by KeepHistory.
+// It waits for the in-progress collection to finish.
+func (gc *GC) History() []Report {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.historyLocked()
+}
+
+// historyLocked returns a copy of history, oldest first.
+func (gc *GC) historyLocked() []Report {
+	h := make([]Report, 0, len(gc.history))
+	if len(gc.history) < cap(gc.history) {
+		return append(h, gc.history...)
+	}
+	h = append(h, gc.history[gc.historyNext:]...)
+	return append(h, gc.history[:gc.historyNext]...)
+}
+
+// record adds the report to history.
+func (gc *GC) record(r Report) {
+	n := cap(gc.history)
+	if n == 0 {
+		return
+	}
+	if len(gc.history) < n {
+		gc.history = append(gc.history, r)
+	} else {
+		gc.history[gc.historyNext] = r
+	}
+	gc.historyNext = (gc.historyNext + 1) % n
+}
@@ -0,0 +1,140 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"time"
+)
+
+// Hooks contains functions called by the collector, which are set by
+// the methods with the same names. It is a part of Config, so that hooks
+// can be changed together with other settings by Reconfigure.
+type Hooks struct {
+	OnRunStart func() bool
+	OnRunEnd   func(Report)
+	OnError    func(error)
+	OnDelete   func(path string, fi os.FileInfo)
+}
+
+// OnRunStart sets the function called before each collection,
+// and returns the same GC.
+//
+// The function decides whether the collection should proceed: if it
+// returns false, the collection is skipped, and the returned report has
+// Skipped set to true. This allows vetoing collections based on
+// conditions unknown to the collector, such as an ongoing backup
+// of the session directory.
+//
+// For background collections, the function is called on the collector
+// goroutine. It may call methods of GC.
+func (gc *GC) OnRunStart(f func() bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.onRunStart = f
+	return gc
+}
+
+// OnRunEnd sets the function called after each collection with the
+// report describing its results, and returns the same GC. The function
+// is called even if the collection failed, but not if it was skipped.
+//
+// For background collections, the function is called on the collector
+// goroutine. It may call methods of GC.
+func (gc *GC) OnRunEnd(f func(Report)) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.onRunEnd = f
+	return gc
+}
+
+// OnError sets the function called when a background collection fails,
+// and returns the same GC.
+//
+// The function is called with the error returned by the collection, for
+// example, when the session directory is unreadable. If the collection
+// failed to remove some session files, the function is called with an
+// error describing the number of failures and the first of them; see
+// Report.Errors for all of them. Errors of collections run by Collect
+// are returned to the caller instead.
+//
+// The function is called on the collector goroutine. It may call methods
+// of GC.
+func (gc *GC) OnError(f func(error)) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.onError = f
+	return gc
+}
+
+// errorsBuffer is the capacity of the channel returned by Errors.
+const errorsBuffer = 16
+
+// Errors returns a channel that receives errors from background
+// collections, the same ones that are passed to the function set by
+// OnError. For example:
+//
+//   go func() {
+//           for err := range gc.Errors() {
+//                   log.Printf("session collection failed: %v", err)
+//           }
+//   }()
+//
+// The channel is buffered; if it is full, new errors are dropped rather
+// than blocking collections. The channel is never closed, and every call
+// returns the same channel.
+func (gc *GC) Errors() <-chan error {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.errs == nil {
+		gc.errs = make(chan error, errorsBuffer)
+	}
+	return gc.errs
+}
+
+// OnDelete sets the function called for each session file removed by
+// a collection, or moved to trash in soft-delete mode, and returns the
+// same GC. It can be used, for example, to emit audit events or to
+// invalidate caches keyed by session ID.
+//
+// The function receives the path of the file and the information about
+// it from before the removal. The path is not redacted by RedactIDs.
+//
+// The function is called in the middle of the collection, so it must
+// not call methods of GC.
+func (gc *GC) OnDelete(f func(path string, fi os.FileInfo)) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.onDelete = f
+	return gc
+}
+
+// OnLag sets the function called after a collection if its lag exceeds
+// the given threshold, and returns the same GC. See Report.Lag.
+//
+// For background collections, the function is called on the collector
+// goroutine. It may call methods of GC.
+func (gc *GC) OnLag(threshold time.Duration, f func(lag time.Duration)) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.lagThreshold = threshold
+	gc.onLag = f
+	return gc
+}
+
+// Lag returns the lag of the last collection. See Report.Lag.
+func (gc *GC) Lag() time.Duration {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.lag
+}
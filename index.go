@@ -0,0 +1,112 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// indexEntry describes a live session in the index.
+type indexEntry struct {
+	info    SessionInfo
+	expires time.Time
+}
+
+// Index enables or disables the in-memory index of live sessions and
+// returns the same GC.
+//
+// If enabled, each collection records live sessions it finds, so that
+// Lookup and Len can answer questions about sessions without accessing
+// the disk. The index reflects the state at the end of the last
+// collection: sessions created since then are not in it, and sessions
+// removed by the store may still be. The index is not maintained in
+// strict memory mode.
+func (gc *GC) Index(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.indexed = enable
+	if !enable {
+		gc.indexMu.Lock()
+		gc.index = nil
+		gc.indexMu.Unlock()
+	}
+	return gc
+}
+
+// Lookup returns information about the live session with the given ID
+// and the time when it expires by age from the index. In multi-tenant
+// mode, the ID must be prefixed with the tenant name and a slash, as in
+// Restore. If the session is not in the index, ok is false.
+//
+// The expiry time is zero if it is not known, because ExpireFunc decides
+// whether sessions are expired, or if the session is pinned and never
+// expires.
+//
+// It doesn't wait for an in-progress collection.
+func (gc *GC) Lookup(id string) (info SessionInfo, expires time.Time, ok bool) {
+	gc.indexMu.RLock()
+	defer gc.indexMu.RUnlock()
+	e, ok := gc.index[id]
+	return e.info, e.expires, ok
+}
+
+// Len returns the number of live sessions in the index.
+//
+// It doesn't wait for an in-progress collection.
+func (gc *GC) Len() int {
+	gc.indexMu.RLock()
+	defer gc.indexMu.RUnlock()
+	return len(gc.index)
+}
+
+// indexKey returns the index key for the session file.
+func indexKey(tenant string, info SessionInfo) string {
+	if tenant == "" {
+		return info.ID
+	}
+	return path.Join(tenant, info.ID)
+}
+
+// indexAdd adds the live session file to the index being built.
+func (gc *GC) indexAdd(tenant string, f sessionFile, expires time.Time) {
+	if gc.nextIndex == nil {
+		return
+	}
+	info := newSessionInfo(tenant, filepath.Dir(f.path), f.fi, false)
+	gc.nextIndex[indexKey(tenant, info)] = indexEntry{info, expires}
+}
+
+// indexRemove removes the session file from the index being built.
+func (gc *GC) indexRemove(f sessionFile) {
+	if gc.nextIndex == nil {
+		return
+	}
+	delete(gc.nextIndex, indexKey(f.tenant, newSessionInfo(f.tenant, "", f.fi, false)))
+}
+
+// startIndex starts building the index for the current collection.
+func (gc *GC) startIndex() {
+	gc.nextIndex = nil
+	if gc.indexed && !gc.strictMemory {
+		gc.nextIndex = make(map[string]indexEntry)
+	}
+}
+
+// finishIndex replaces the index with the one built by the collection,
+// unless the collection failed and the built index may be incomplete.
+func (gc *GC) finishIndex(err error) {
+	if gc.nextIndex != nil && err == nil {
+		gc.indexMu.Lock()
+		gc.index = gc.nextIndex
+		gc.indexMu.Unlock()
+	}
+	gc.nextIndex = nil
+}
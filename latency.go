@@ -0,0 +1,97 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// latencyProbeName is the name of the file in the collector directory
+	// written to measure write latency.
+	latencyProbeName = ".fsgc-probe"
+
+	// latencyProbeEvery is the number of removals between probes.
+	latencyProbeEvery = 32
+
+	// maxLatencyWaits is the maximum number of waits for write latency
+	// to go down before the collection continues anyway.
+	maxLatencyWaits = 10
+)
+
+// YieldOnLatency enables pausing removals when the write latency of
+// the session directory exceeds the given threshold, and returns the same
+// GC. Zero disables it, which is the default.
+//
+// During collections, after every few removals the collector measures how
+// long it takes to write and sync a tiny probe file in the collector
+// directory. If it takes longer than the threshold, the disk is likely
+// busy serving the application, so the collector waits and probes again,
+// up to a limit, before continuing. This yields disk bandwidth to the live
+// application on shared disks without configuring a fixed removal rate.
+func (gc *GC) YieldOnLatency(threshold time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.latencyThreshold = threshold
+	return gc
+}
+
+// yieldToWrites waits while the write latency exceeds the threshold.
+// It is called before each removal.
+func (gc *GC) yieldToWrites() {
+	if gc.latencyThreshold <= 0 {
+		return
+	}
+	gc.sinceProbe++
+	if gc.sinceProbe < latencyProbeEvery {
+		return
+	}
+	gc.sinceProbe = 0
+	for i := 0; i < maxLatencyWaits && !gc.canceled(); i++ {
+		latency, err := gc.probeLatency()
+		if err != nil || latency <= gc.latencyThreshold {
+			return
+		}
+		// Wait several times longer than the write took,
+		// giving the disk time to drain its queue.
+		time.Sleep(4 * latency)
+	}
+}
+
+// probeLatency returns the time it takes to write and sync the probe file.
+func (gc *GC) probeLatency() (time.Duration, error) {
+	if gc.probe == nil {
+		f, err := os.OpenFile(filepath.Join(gc.dir, latencyProbeName), os.O_WRONLY|os.O_CREATE, 0600)
+		if err != nil {
+			return 0, err
+		}
+		gc.probe = f
+	}
+	start := time.Now()
+	if _, err := gc.probe.WriteAt([]byte{0}, 0); err != nil {
+		return 0, err
+	}
+	if err := gc.probe.Sync(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// closeProbe closes and removes the probe file, if any.
+func (gc *GC) closeProbe() {
+	gc.sinceProbe = 0
+	if gc.probe == nil {
+		return
+	}
+	gc.probe.Close()
+	os.Remove(gc.probe.Name())
+	gc.probe = nil
+}
@@ -0,0 +1,93 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// sidecarExt is the extension of sidecar files recording session
+// creation time, which is the modification time of the sidecar.
+const sidecarExt = ".created"
+
+// orphanSidecarAge is the age after which sidecar files without
+// session files are removed.
+const orphanSidecarAge = time.Minute
+
+// RecordCreation records the creation time of the session stored in the file
+// with the given path, unless it is already recorded. The time is recorded
+// in a sidecar file next to the session file.
+//
+// A wrapper around FilesystemStore can call it after saving a session
+// to enable MaxLifetime:
+//
+//   func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+//           if err := s.FilesystemStore.Save(r, w, session); err != nil {
+//                   return err
+//           }
+//           return fsgc.RecordCreation(filepath.Join(s.path, "session_"+session.ID))
+//   }
+//
+func RecordCreation(path string) error {
+	f, err := os.OpenFile(path+sidecarExt, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil // already recorded
+		}
+		return err
+	}
+	return f.Close()
+}
+
+// MaxLifetime sets the maximum lifetime of sessions and returns the same GC.
+//
+// Sessions with creation time recorded by RecordCreation are removed
+// when they are older than the given lifetime, even if they were modified
+// recently. Zero disables the limit.
+func (gc *GC) MaxLifetime(d time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.maxLifetime = d
+	return gc
+}
+
+// isOverLifetime reports whether the session stored in the file with
+// the given path was created earlier than max lifetime ago.
+func (gc *GC) isOverLifetime(path string, now time.Time) bool {
+	fi, err := os.Lstat(path + sidecarExt)
+	if err != nil {
+		return false // creation time unknown
+	}
+	return now.Sub(fi.ModTime()) > gc.maxLifetime
+}
+
+// isSidecar reports whether the file name is a name of sidecar file.
+func isSidecar(name string) bool {
+	return strings.HasPrefix(name, sessionPrefix) && strings.HasSuffix(name, sidecarExt)
+}
+
+// removeOrphanSidecars removes sidecar files in dir for which there
+// are no session files.
+func removeOrphanSidecars(dir string, sessions, sidecars []os.FileInfo, now time.Time) {
+	if len(sidecars) == 0 {
+		return
+	}
+	exists := make(map[string]bool, len(sessions))
+	for _, fi := range sessions {
+		exists[fi.Name()] = true
+	}
+	for _, fi := range sidecars {
+		if !exists[strings.TrimSuffix(fi.Name(), sidecarExt)] && now.Sub(fi.ModTime()) > orphanSidecarAge {
+			os.Remove(filepath.Join(dir, fi.Name())) // ignore errors
+		}
+	}
+}
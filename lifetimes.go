@@ -0,0 +1,78 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"sort"
+	"time"
+)
+
+// Lifetimes describes the observed lifetimes of sessions removed by
+// a collection, from their creation to their removal. See
+// GC.LifetimeAnalytics.
+type Lifetimes struct {
+	Count   int           // number of sessions with known creation time
+	Unknown int           // number of sessions with unknown creation time
+	P50     time.Duration // median lifetime
+	P90     time.Duration // 90th percentile of lifetimes
+	P99     time.Duration // 99th percentile of lifetimes
+	Max     time.Duration // longest lifetime
+}
+
+// LifetimeAnalytics enables or disables recording lifetimes of removed
+// sessions, and returns the same GC.
+//
+// When enabled, each collection reports percentiles of lifetimes of the
+// sessions it removed in Report.Lifetimes, which tells how long users
+// actually stay logged in. The lifetime of a session is the time from its
+// creation, as recorded by RecordCreation, to its removal; sessions
+// without recorded creation time are only counted. Lifetimes are not
+// recorded in strict memory mode.
+func (gc *GC) LifetimeAnalytics(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.lifetimeAnalytics = enable
+	return gc
+}
+
+// recordLifetime records the lifetime of the removed session file.
+func (gc *GC) recordLifetime(f sessionFile) {
+	if !gc.lifetimeAnalytics || gc.strictMemory {
+		return
+	}
+	path := f.path
+	if dst, ok := gc.trashPath(f.tenant, f); ok {
+		path = dst // sidecar was moved with the session
+	}
+	fi, err := os.Lstat(path + sidecarExt)
+	if err != nil {
+		gc.lifetimeUnknown++
+		return
+	}
+	gc.lifetimes = append(gc.lifetimes, time.Since(fi.ModTime()))
+}
+
+// lifetimeStats returns lifetimes recorded by the collection
+// and resets them.
+func (gc *GC) lifetimeStats() Lifetimes {
+	l := Lifetimes{Count: len(gc.lifetimes), Unknown: gc.lifetimeUnknown}
+	if n := len(gc.lifetimes); n > 0 {
+		d := gc.lifetimes
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		// Nearest-rank percentiles.
+		rank := func(p int) time.Duration {
+			return d[(p*n+99)/100-1]
+		}
+		l.P50, l.P90, l.P99, l.Max = rank(50), rank(90), rank(99), d[n-1]
+	}
+	gc.lifetimes = nil
+	gc.lifetimeUnknown = 0
+	return l
+}
@@ -0,0 +1,187 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"container/heap"
+	"sync"
+	"time"
+)
+
+// Manager runs collections for multiple collectors, each on its own
+// schedule, using a single goroutine.
+//
+// Collectors added to a manager must not be started by calling Start.
+// Each collector runs every interval set for it by Interval or AutoInterval.
+type Manager struct {
+	mu      sync.Mutex
+	queue   managerQueue
+	items   map[*GC]*managerItem
+	wake    chan struct{}
+	stop    chan struct{}
+	running bool
+}
+
+// NewManager returns a new manager. It must be started by calling Start.
+func NewManager() *Manager {
+	return &Manager{
+		items: make(map[*GC]*managerItem),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+// Add adds the collector to the manager and returns the same manager.
+// The first collection will happen after the collector interval.
+func (m *Manager) Add(gc *GC) *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.items[gc]; ok {
+		return m // already added
+	}
+	item := &managerItem{gc: gc, next: time.Now().Add(gc.currentInterval())}
+	m.items[gc] = item
+	heap.Push(&m.queue, item)
+	m.notify()
+	return m
+}
+
+// Remove removes the collector from the manager.
+func (m *Manager) Remove(gc *GC) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	item, ok := m.items[gc]
+	if !ok {
+		return
+	}
+	delete(m.items, gc)
+	if item.index >= 0 {
+		heap.Remove(&m.queue, item.index)
+	}
+	m.notify()
+}
+
+// Start starts the manager. It returns the same manager.
+//
+// The manager runs on its own goroutine, and must be stopped by calling
+// Stop when it is no longer needed.
+func (m *Manager) Start() *Manager {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running {
+		return m // already started
+	}
+	m.running = true
+	m.stop = make(chan struct{})
+	go m.run(m.stop)
+	return m
+}
+
+// Stop stops the manager. It can be restarted again by calling Start.
+func (m *Manager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.running {
+		return // not started
+	}
+	m.running = false
+	close(m.stop)
+}
+
+// notify wakes up the manager goroutine to reconsider the schedule.
+func (m *Manager) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// maxManagerSleep is the time the manager sleeps if there are no
+// collectors.
+const maxManagerSleep = time.Hour
+
+// run runs collections when they are due until stop is closed.
+func (m *Manager) run(stop chan struct{}) {
+	for {
+		d := maxManagerSleep
+		m.mu.Lock()
+		if len(m.queue) > 0 {
+			d = m.queue[0].next.Sub(time.Now())
+		}
+		m.mu.Unlock()
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+			m.runDue()
+		case <-m.wake:
+			timer.Stop()
+		case <-stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// runDue runs collections that are due and reschedules them.
+func (m *Manager) runDue() {
+	for {
+		m.mu.Lock()
+		if len(m.queue) == 0 || m.queue[0].next.After(time.Now()) {
+			m.mu.Unlock()
+			return
+		}
+		item := heap.Pop(&m.queue).(*managerItem)
+		m.mu.Unlock()
+
+		if r, err := item.gc.collectBackground(); !r.Skipped {
+			item.gc.handleReport(r, err)
+		}
+
+		m.mu.Lock()
+		if m.items[item.gc] == item {
+			// Still managed, reschedule.
+			item.next = time.Now().Add(item.gc.currentInterval())
+			heap.Push(&m.queue, item)
+		}
+		m.mu.Unlock()
+	}
+}
+
+// managerItem is a collector scheduled by manager.
+type managerItem struct {
+	gc    *GC
+	next  time.Time // time of the next collection
+	index int       // index in queue or -1
+}
+
+// managerQueue is a priority queue of scheduled collectors
+// implementing heap.Interface.
+type managerQueue []*managerItem
+
+func (q managerQueue) Len() int           { return len(q) }
+func (q managerQueue) Less(i, j int) bool { return q[i].next.Before(q[j].next) }
+
+func (q managerQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *managerQueue) Push(x interface{}) {
+	item := x.(*managerItem)
+	item.index = len(*q)
+	*q = append(*q, item)
+}
+
+func (q *managerQueue) Pop() interface{} {
+	old := *q
+	item := old[len(old)-1]
+	old[len(old)-1] = nil
+	item.index = -1
+	*q = old[:len(old)-1]
+	return item
+}
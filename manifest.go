@@ -0,0 +1,112 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SimulateFromManifest returns information about sessions that the collector
+// would remove if the directory contained files described by the manifest.
+// It doesn't touch the filesystem.
+//
+// Each line of the manifest describes a file with its name, modification time
+// as Unix time in seconds, and size in bytes, separated by spaces, as printed
+// by find(1):
+//
+//   find /path/to/sessions -type f -printf '%P %T@ %s\n'
+//
+// In multi-tenant mode, names must be prefixed with the tenant name and
+// a slash. Empty lines and lines starting with # are ignored.
+func (gc *GC) SimulateFromManifest(r io.Reader) ([]SessionInfo, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	tenants := make(map[string][]sessionFile)
+	s := bufio.NewScanner(r)
+	for line := 1; s.Scan(); line++ {
+		text := strings.TrimSpace(s.Text())
+		if text == "" || text[0] == '#' {
+			continue
+		}
+		f, err := parseManifestLine(text)
+		if err != nil {
+			return nil, fmt.Errorf("fsgc: manifest line %d: %v", line, err)
+		}
+		tenant, name := path.Split(f.path)
+		tenant = strings.TrimSuffix(tenant, "/")
+		if strings.Contains(tenant, "/") || (tenant != "") != gc.tenants || !gc.isSession(name) {
+			continue
+		}
+		tenants[tenant] = append(tenants[tenant], f)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(tenants))
+	for t := range tenants {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	now := gc.now()
+	var sessions []SessionInfo
+	for _, t := range names {
+		for _, f := range gc.victims(tenants[t], gc.policyFor(t), now) {
+			info := newSessionInfo(t, filepath.Join(gc.dir, t), f.fi, true)
+			info.Reason = f.reason
+			sessions = append(sessions, info)
+		}
+	}
+	return sessions, nil
+}
+
+// parseManifestLine parses a manifest line into a session file.
+func parseManifestLine(line string) (sessionFile, error) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return sessionFile{}, fmt.Errorf("expected 3 fields, got %d", len(fields))
+	}
+	secs, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil {
+		return sessionFile{}, err
+	}
+	size, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return sessionFile{}, err
+	}
+	sec, frac := math.Modf(secs)
+	fi := &manifestFileInfo{
+		name:    path.Base(fields[0]),
+		size:    size,
+		modTime: time.Unix(int64(sec), int64(frac*1e9)),
+	}
+	return sessionFile{path: fields[0], fi: fi}, nil
+}
+
+// manifestFileInfo implements os.FileInfo for manifest records.
+type manifestFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (fi *manifestFileInfo) Name() string       { return fi.name }
+func (fi *manifestFileInfo) Size() int64        { return fi.size }
+func (fi *manifestFileInfo) Mode() os.FileMode  { return 0600 }
+func (fi *manifestFileInfo) ModTime() time.Time { return fi.modTime }
+func (fi *manifestFileInfo) IsDir() bool        { return false }
+func (fi *manifestFileInfo) Sys() interface{}   { return nil }
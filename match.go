@@ -0,0 +1,87 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"path/filepath"
+	"regexp"
+)
+
+// MatchGlob sets the shell pattern, as used by filepath.Match, that names
+// of session files must match, and returns the same GC. It replaces the
+// default "session_" prefix used by FilesystemStore, so that the collector
+// can clean up session files written by other stores, for example:
+//
+//   gc.MatchGlob("sess_*.json")
+//
+// A malformed pattern matches nothing; use Config.Validate to check it.
+// If pattern is empty, the default prefix is used.
+func (gc *GC) MatchGlob(pattern string) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.setMatchGlob(pattern)
+	return gc
+}
+
+// setMatchGlob sets the pattern of session file names.
+func (gc *GC) setMatchGlob(pattern string) {
+	gc.matchGlob, gc.matchRegexp = pattern, ""
+	gc.match = nil
+	if pattern != "" {
+		gc.match = func(name string) bool {
+			ok, _ := filepath.Match(pattern, name)
+			return ok
+		}
+	}
+}
+
+// MatchRegexp sets the regular expression that names of session files
+// must match, and returns the same GC. See MatchGlob. If re is nil,
+// the default prefix is used.
+func (gc *GC) MatchRegexp(re *regexp.Regexp) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.setMatchRegexp(re)
+	return gc
+}
+
+// setMatchRegexp sets the regular expression of session file names.
+func (gc *GC) setMatchRegexp(re *regexp.Regexp) {
+	gc.matchGlob, gc.matchRegexp = "", ""
+	gc.match = nil
+	if re != nil {
+		gc.matchRegexp = re.String()
+		gc.match = re.MatchString
+	}
+}
+
+// MatchFunc sets the function deciding whether the file with the given
+// name is a session file, and returns the same GC. See MatchGlob.
+//
+// It allows arbitrary selection, for example, skipping sessions with
+// some ID prefix. The function may be called concurrently from
+// collections and other methods. If f is nil, the default prefix is used.
+func (gc *GC) MatchFunc(f func(name string) bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.matchGlob, gc.matchRegexp = "", ""
+	gc.match = f
+	return gc
+}
+
+// fileName returns the name of the session file with the given ID.
+func (gc *GC) fileName(id string) string {
+	name := sessionPrefix + id
+	if gc.match != nil && !gc.match(name) {
+		return id
+	}
+	return name
+}
@@ -0,0 +1,85 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+// memoryFSFraction is the fraction of the memory-backed filesystem size
+// that session files may take by default.
+const memoryFSFraction = 0.5
+
+// MemoryFSPolicy sets the quotas applied when the session directory is
+// on a memory-backed filesystem, such as tmpfs or ramfs, and returns
+// the same GC.
+//
+// The collector detects memory-backed filesystems of each session
+// directory, including tenant and added directories, at the start of each
+// collection. On them, memory is the scarce resource, so MaxSessions and
+// MaxBytes from p are applied to policies of such directories that don't
+// set their own quotas. If p doesn't set MaxBytes, session files are
+// limited to half of the filesystem size. MaxAge of p is ignored. Without
+// calling MemoryFSPolicy, no quotas are added.
+//
+// Regardless of this setting, syncing directories (see SyncBatch) is
+// disabled on memory-backed filesystems, where it is pointless.
+//
+// Memory-backed filesystems are only detected on Linux.
+func (gc *GC) MemoryFSPolicy(p Policy) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.memFSPolicy = p
+	gc.memFSAware = true
+	return gc
+}
+
+// memFSInfo describes the filesystem of a directory.
+type memFSInfo struct {
+	memory bool  // filesystem is memory-backed
+	size   int64 // size of memory-backed filesystem
+}
+
+// detectMemoryFS forgets memory-backed filesystems detected by the previous
+// collection, so that they are detected again.
+func (gc *GC) detectMemoryFS() {
+	gc.memFSDirs = nil
+}
+
+// memoryFSOf reports whether the directory is on a memory-backed
+// filesystem, and returns its size.
+func (gc *GC) memoryFSOf(dir string) (bool, int64) {
+	info, ok := gc.memFSDirs[dir]
+	if !ok {
+		info.memory, info.size = memoryFS(dir)
+		if gc.memFSDirs == nil {
+			gc.memFSDirs = make(map[string]memFSInfo)
+		}
+		gc.memFSDirs[dir] = info
+	}
+	return info.memory, info.size
+}
+
+// applyMemoryFSPolicy sets quotas of the policy for the directory on
+// a memory-backed filesystem, unless the policy sets its own.
+func (gc *GC) applyMemoryFSPolicy(p Policy, dir string) Policy {
+	if !gc.memFSAware {
+		return p
+	}
+	memory, size := gc.memoryFSOf(dir)
+	if !memory {
+		return p
+	}
+	if p.MaxSessions == 0 {
+		p.MaxSessions = gc.memFSPolicy.MaxSessions
+	}
+	if p.MaxBytes == 0 {
+		p.MaxBytes = gc.memFSPolicy.MaxBytes
+		if p.MaxBytes == 0 {
+			p.MaxBytes = int64(float64(size) * memoryFSFraction)
+		}
+	}
+	return p
+}
@@ -0,0 +1,30 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "syscall"
+
+// Magic numbers of memory-backed filesystems.
+const (
+	tmpfsMagic = 0x01021994
+	ramfsMagic = 0x858458f6
+)
+
+// memoryFS reports whether dir is on a memory-backed filesystem,
+// and returns the filesystem size in bytes.
+func memoryFS(dir string) (bool, int64) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return false, 0
+	}
+	switch uint32(st.Type) {
+	case tmpfsMagic, ramfsMagic:
+		return true, int64(st.Blocks) * int64(st.Bsize)
+	}
+	return false, 0
+}
@@ -0,0 +1,35 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// openFiles returns the set of paths of files open by this process.
+// It returns nil if the set cannot be determined.
+func openFiles() map[string]bool {
+	const fdDir = "/proc/self/fd"
+	f, err := os.Open(fdDir)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	names, err := f.Readdirnames(0)
+	if err != nil {
+		return nil
+	}
+	open := make(map[string]bool, len(names))
+	for _, name := range names {
+		if target, err := os.Readlink(filepath.Join(fdDir, name)); err == nil {
+			open[target] = true
+		}
+	}
+	return open
+}
@@ -0,0 +1,17 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+//go:build !linux
+// +build !linux
+
+package fsgc
+
+// openFiles returns the set of paths of files open by this process.
+// It returns nil if the set cannot be determined.
+func openFiles() map[string]bool {
+	return nil
+}
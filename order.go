@@ -0,0 +1,63 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "sort"
+
+// DeleteOrder specifies the order in which expired sessions are removed.
+type DeleteOrder int
+
+const (
+	// Oldest removes the oldest sessions first.
+	Oldest DeleteOrder = iota
+
+	// Largest removes the largest session files first,
+	// reclaiming disk space faster.
+	Largest
+
+	// DirectoryOrder removes sessions in the order they are
+	// returned by the filesystem, avoiding sorting.
+	DirectoryOrder
+)
+
+// String returns the name of the order.
+func (o DeleteOrder) String() string {
+	switch o {
+	case Oldest:
+		return "oldest"
+	case Largest:
+		return "largest"
+	case DirectoryOrder:
+		return "directory"
+	}
+	return "unknown"
+}
+
+// Order sets the order in which expired sessions are removed
+// and returns the same GC. The default order is Oldest.
+func (gc *GC) Order(o DeleteOrder) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.order = o
+	return gc
+}
+
+// sortSessions sorts session files in the given order.
+func sortSessions(files []sessionFile, o DeleteOrder) {
+	switch o {
+	case Oldest:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].fi.ModTime().Before(files[j].fi.ModTime())
+		})
+	case Largest:
+		sort.SliceStable(files, func(i, j int) bool {
+			return files[i].fi.Size() > files[j].fi.Size()
+		})
+	}
+}
@@ -0,0 +1,18 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+//go:build !linux
+// +build !linux
+
+package fsgc
+
+import "os"
+
+// fileOwner returns the user ID of the file owner.
+func fileOwner(fi os.FileInfo) (int, bool) {
+	return 0, false
+}
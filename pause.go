@@ -0,0 +1,45 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "context"
+
+// Pause suspends background collections without stopping the collector.
+//
+// While paused, scheduled collections are skipped, but the schedule and
+// configuration are kept, so that after Resume collections continue at
+// the same ticks. Pause waits for the in-progress collection, if any, to
+// finish, so after it returns the collector doesn't remove anything until
+// it is resumed, which makes it suitable for maintenance windows, such as
+// backups of the session directory. This includes collections run by
+// a Manager. Collections run by Collect are not affected.
+func (gc *GC) Pause() {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.paused = true
+}
+
+// Resume resumes background collections suspended by Pause.
+func (gc *GC) Resume() {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.paused = false
+}
+
+// Paused reports whether background collections are suspended by Pause.
+func (gc *GC) Paused() bool {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.paused
+}
+
+// collectBackground runs a scheduled collection, which is skipped
+// while the collector is paused.
+func (gc *GC) collectBackground() (Report, error) {
+	return gc.collectReport(context.Background(), true)
+}
@@ -0,0 +1,90 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// ErrDirChanged is returned by a collection that stopped because
+// a session directory was replaced, for example, renamed and recreated,
+// while it was running.
+var ErrDirChanged = errors.New("fsgc: session directory changed during collection")
+
+// pinnedDir is a session directory opened once per collection,
+// from which session files are removed.
+type pinnedDir struct {
+	f  *os.File
+	fi os.FileInfo
+}
+
+// pinDir returns the pinned directory, opening it on first use
+// during the collection.
+func (gc *GC) pinDir(dir string) (*pinnedDir, error) {
+	if d, ok := gc.pinned[dir]; ok {
+		return d, nil
+	}
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if gc.pinned == nil {
+		gc.pinned = make(map[string]*pinnedDir)
+	}
+	d := &pinnedDir{f: f, fi: fi}
+	gc.pinned[dir] = d
+	return d, nil
+}
+
+// unpinDirs closes directories pinned by the collection.
+func (gc *GC) unpinDirs() {
+	for dir, d := range gc.pinned {
+		d.f.Close()
+		delete(gc.pinned, dir)
+	}
+}
+
+// check returns ErrDirChanged if the directory at the given path is no
+// longer the pinned one.
+func (d *pinnedDir) check(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil || !os.SameFile(fi, d.fi) {
+		return ErrDirChanged
+	}
+	return nil
+}
+
+// unlink removes the session file relative to its pinned directory,
+// unless it changed since it was examined (see removeSession). Both the
+// check and the removal are relative to the pinned directory, so they
+// see the same file even if the directory is renamed meanwhile. If the
+// directory has changed, it stops the collection with ErrDirChanged
+// before the next removal.
+func (gc *GC) unlink(name string, fi os.FileInfo) error {
+	dir := filepath.Dir(name)
+	d, err := gc.pinDir(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.check(dir); err != nil {
+		gc.abort = err
+		return err
+	}
+	base := filepath.Base(name)
+	if err := checkUnchangedAt(d.f, dir, base, fi); err != nil {
+		return err
+	}
+	return unlinkAt(d.f, dir, base)
+}
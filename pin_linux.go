@@ -0,0 +1,54 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// oPath is the O_PATH open flag, which is the same on all architectures
+// supported by Go, but is not defined by package syscall on some of them.
+const oPath = 0x200000
+
+// checkUnchangedAt returns errChanged if the modification time of the file
+// with the given name in the open directory at path differs from the one
+// in fi, like checkUnchanged, but without resolving path again. Symbolic
+// links are not followed.
+func checkUnchangedAt(d *os.File, path, name string, fi os.FileInfo) error {
+	fd, err := syscall.Openat(int(d.Fd()), name, oPath|syscall.O_NOFOLLOW|syscall.O_CLOEXEC, 0)
+	if _, ok := fi.(unreadableInfo); ok {
+		if err == nil {
+			syscall.Close(fd)
+		}
+		return checkUnreadable(err)
+	}
+	if err != nil {
+		return &os.PathError{Op: "lstat", Path: filepath.Join(path, name), Err: err}
+	}
+	defer syscall.Close(fd)
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return &os.PathError{Op: "lstat", Path: filepath.Join(path, name), Err: err}
+	}
+	if !time.Unix(int64(st.Mtim.Sec), int64(st.Mtim.Nsec)).Equal(fi.ModTime()) {
+		return errChanged
+	}
+	return nil
+}
+
+// unlinkAt removes the file with the given name from the open directory
+// at path.
+func unlinkAt(d *os.File, path, name string) error {
+	if err := syscall.Unlinkat(int(d.Fd()), name); err != nil {
+		return &os.PathError{Op: "remove", Path: filepath.Join(path, name), Err: err}
+	}
+	return nil
+}
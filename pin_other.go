@@ -0,0 +1,28 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+//go:build !linux
+// +build !linux
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// checkUnchangedAt returns errChanged if the modification time of the file
+// with the given name in the directory at path differs from the one in fi.
+func checkUnchangedAt(d *os.File, path, name string, fi os.FileInfo) error {
+	return checkUnchanged(filepath.Join(path, name), fi)
+}
+
+// unlinkAt removes the file with the given name from the open directory
+// at path.
+func unlinkAt(d *os.File, path, name string) error {
+	return os.Remove(filepath.Join(path, name))
+}
@@ -0,0 +1,57 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"math"
+	"sync/atomic"
+	"time"
+)
+
+// Pressure returns a value between 0 and 1 indicating how stressed
+// the session store is: 0 means no pressure, 1 means critical.
+//
+// It is the maximum of the following ratios:
+//
+//   - expired sessions left after the last collection to all sessions
+//     it found;
+//   - lag of the expired sessions left after the last collection to max
+//     age (see Report.Lag);
+//   - used to total space on the filesystem of the session directory
+//     (only on Linux).
+//
+// Applications can use it, for example, to shorten lifetimes of new
+// sessions when the store is under stress. Pressure doesn't wait for
+// an in-progress collection.
+func (gc *GC) Pressure() float64 {
+	p := math.Max(
+		math.Float64frombits(atomic.LoadUint64(&gc.stats.backlog)),
+		math.Float64frombits(atomic.LoadUint64(&gc.stats.lagRatio)),
+	)
+	dir, _ := gc.dirValue.Load().(string)
+	if usage, ok := diskUsage(dir); ok {
+		p = math.Max(p, usage)
+	}
+	return math.Min(p, 1)
+}
+
+// updatePressure updates inputs for Pressure with the collection report
+// and the lag of expired sessions left after it.
+func (gc *GC) updatePressure(r Report, lag time.Duration) {
+	var backlog, lagRatio float64
+	// Removed also counts sessions removed by quotas, which are not
+	// expired, so the number of left sessions may be negative.
+	if left := r.Expired - r.Removed - r.Vanished; r.Scanned > 0 && left > 0 {
+		backlog = float64(left) / float64(r.Scanned)
+	}
+	if gc.maxAge > 0 {
+		lagRatio = math.Min(float64(lag)/float64(gc.maxAge), 1)
+	}
+	atomic.StoreUint64(&gc.stats.backlog, math.Float64bits(backlog))
+	atomic.StoreUint64(&gc.stats.lagRatio, math.Float64bits(lagRatio))
+}
@@ -0,0 +1,94 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "time"
+
+// Progress describes the progress of a long collection.
+type Progress struct {
+	RunID     string        // identifier of the collection, see GC.RunID
+	Processed int           // number of expired session files processed so far
+	Removed   int           // number of session files removed so far
+	Remaining int           // estimated number of session files left to process
+	Elapsed   time.Duration // time since the collection started
+	Left      time.Duration // estimated time left until the end of removal
+}
+
+// progressState tracks the progress of the current collection.
+type progressState struct {
+	start     time.Time
+	next      time.Time // time of the next progress report
+	total     int
+	processed int
+	removed   int
+}
+
+// OnProgress enables progress reports for long collections and returns
+// the same GC.
+//
+// While removing sessions, every given period the collector calls f
+// with the progress of the collection, so that operators know that
+// a long cleanup, such as the first one of a big directory, is advancing.
+// Collections that take less than the period are not reported.
+// If f is nil, progress is reported to the logger set by Logger.
+// If every is zero, progress reports are disabled.
+//
+// The function is called on the goroutine running the collection in
+// the middle of it, so it must not call methods of GC.
+func (gc *GC) OnProgress(every time.Duration, f func(Progress)) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.progressEvery = every
+	gc.onProgress = f
+	return gc
+}
+
+// startProgress starts tracking the progress of removal of the given
+// number of expired session files.
+func (gc *GC) startProgress(start time.Time, total int) {
+	gc.progress = progressState{
+		start: start,
+		next:  start.Add(gc.progressEvery),
+		total: total,
+	}
+}
+
+// advanceProgress records processing of a session file
+// and reports progress if it is due.
+func (gc *GC) advanceProgress(removed bool) {
+	p := &gc.progress
+	p.processed++
+	if removed {
+		p.removed++
+	}
+	if gc.progressEvery <= 0 || (gc.onProgress == nil && gc.logger == nil) {
+		return
+	}
+	now := time.Now()
+	if now.Before(p.next) {
+		return
+	}
+	p.next = now.Add(gc.progressEvery)
+	pr := Progress{
+		RunID:     gc.RunID(),
+		Processed: p.processed,
+		Removed:   p.removed,
+		Elapsed:   now.Sub(p.start),
+	}
+	if p.total > p.processed {
+		pr.Remaining = p.total - p.processed
+		pr.Left = pr.Elapsed / time.Duration(p.processed) * time.Duration(pr.Remaining)
+	}
+	if gc.onProgress != nil {
+		gc.onProgress(pr)
+		return
+	}
+	gc.logger.Printf("fsgc: run %s: processed %d expired session files, removed %d, about %d left (%s)",
+		pr.RunID, pr.Processed, pr.Removed, pr.Remaining, pr.Left)
+}
@@ -0,0 +1,218 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cgroupRateFraction is the fraction of the cgroup write IOPS limit used
+// for removals, leaving the rest for the application.
+const cgroupRateFraction = 0.5
+
+// MaxRemovalRate sets the maximum number of session files removed per
+// second and returns the same GC. If rate is zero, which is the default,
+// the rate is not limited.
+//
+// Limiting the rate spreads the I/O caused by removing many sessions,
+// so that the collector doesn't starve the application of disk bandwidth.
+func (gc *GC) MaxRemovalRate(rate float64) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.maxRate = rate
+	return gc
+}
+
+// CgroupAware enables or disables limiting the removal rate according to
+// the cgroup I/O limits, and returns the same GC.
+//
+// If enabled, before each collection the collector reads the write IOPS
+// limit (wiops in io.max) for the disk containing the session directory,
+// which is the lowest one set for the cgroup v2 it runs in and its
+// ancestors, and limits the removal rate to half of it, or less if
+// MaxRemovalRate is lower. This avoids hitting the
+// throttle, which stalls all I/O of the process. Cgroups are only
+// supported on Linux; on other systems it does nothing.
+func (gc *GC) CgroupAware(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.cgroupAware = enable
+	return gc
+}
+
+// RateWindow is a daily time window with its own removal rate limit.
+// See RateSchedule.
+type RateWindow struct {
+	From time.Duration // start of the window since local midnight
+	To   time.Duration // end of the window since local midnight
+	Rate float64       // max removals per second, zero means unlimited
+}
+
+// contains reports whether the window contains the given time of day.
+// Windows ending before they start wrap around midnight.
+func (w RateWindow) contains(t time.Duration) bool {
+	if w.From <= w.To {
+		return t >= w.From && t < w.To
+	}
+	return t >= w.From || t < w.To
+}
+
+// RateSchedule sets daily time windows with their own removal rate limits,
+// which override MaxRemovalRate, and returns the same GC. The first window
+// containing the current local time applies, and outside of all windows
+// MaxRemovalRate applies. For example, to remove sessions without limits
+// at night and at most 50 per second during business hours:
+//
+//   gc.RateSchedule(
+//           fsgc.RateWindow{From: 9 * time.Hour, To: 18 * time.Hour, Rate: 50},
+//   )
+//
+// The rate is reevaluated for each removal, so long collections switch
+// limits when crossing window boundaries. The limit set by CgroupAware,
+// if lower, still applies.
+func (gc *GC) RateSchedule(windows ...RateWindow) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.rateWindows = append([]RateWindow(nil), windows...)
+	return gc
+}
+
+// startPacing sets the cgroup removal rate limit for the current
+// collection.
+func (gc *GC) startPacing() {
+	gc.cgroupRate = 0
+	if gc.cgroupAware {
+		if iops, ok := cgroupWriteIOPS(gc.dir); ok {
+			gc.cgroupRate = float64(iops) * cgroupRateFraction
+		}
+	}
+	gc.paceNext = time.Time{}
+}
+
+// currentRate returns the removal rate limit at the given time.
+func (gc *GC) currentRate(now time.Time) float64 {
+	rate := gc.maxRate
+	y, m, d := now.Date()
+	day := now.Sub(time.Date(y, m, d, 0, 0, 0, 0, now.Location()))
+	for _, w := range gc.rateWindows {
+		if w.contains(day) {
+			rate = w.Rate
+			break
+		}
+	}
+	if gc.cgroupRate > 0 && (rate <= 0 || gc.cgroupRate < rate) {
+		rate = gc.cgroupRate
+	}
+	return rate
+}
+
+// pace waits until the next removal is allowed by the rate limit
+// and write latency.
+func (gc *GC) pace() {
+	gc.yieldToWrites()
+	now := time.Now()
+	rate := gc.currentRate(now)
+	if rate <= 0 {
+		return
+	}
+	if now.Before(gc.paceNext) {
+		time.Sleep(gc.paceNext.Sub(now))
+		now = gc.paceNext
+	}
+	gc.paceNext = now.Add(time.Duration(float64(time.Second) / rate))
+}
+
+// parseIOMax returns the write IOPS limit for the device with the given
+// major and minor numbers from the content of cgroup io.max file.
+func parseIOMax(data []byte, major, minor uint64) (int64, bool) {
+	dev := devName(major, minor)
+	s := bufio.NewScanner(bytes.NewReader(data))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 || fields[0] != dev {
+			continue
+		}
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "wiops=") {
+				continue
+			}
+			n, err := strconv.ParseInt(strings.TrimPrefix(f, "wiops="), 10, 64)
+			if err != nil || n <= 0 {
+				return 0, false // "max" means no limit
+			}
+			return n, true
+		}
+	}
+	return 0, false
+}
+
+// devName returns the name of the device with the given major and minor
+// numbers, as used by sysfs and cgroup files.
+func devName(major, minor uint64) string {
+	return strconv.FormatUint(major, 10) + ":" + strconv.FormatUint(minor, 10)
+}
+
+// wholeDisk returns the major and minor numbers of the disk containing
+// the partition with the given numbers, or the same numbers if it is not
+// a partition, using the sysfs directory of block devices sysDev, usually
+// /sys/dev/block. Limits in io.max are set for whole disks.
+func wholeDisk(sysDev string, major, minor uint64) (uint64, uint64) {
+	dir, err := filepath.EvalSymlinks(filepath.Join(sysDev, devName(major, minor)))
+	if err != nil {
+		return major, minor
+	}
+	if _, err := os.Stat(filepath.Join(dir, "partition")); err != nil {
+		return major, minor // not a partition
+	}
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(dir), "dev"))
+	if err != nil {
+		return major, minor
+	}
+	i := bytes.IndexByte(data, ':')
+	if i < 0 {
+		return major, minor
+	}
+	diskMajor, err1 := strconv.ParseUint(string(data[:i]), 10, 32)
+	diskMinor, err2 := strconv.ParseUint(string(bytes.TrimSpace(data[i+1:])), 10, 32)
+	if err1 != nil || err2 != nil {
+		return major, minor
+	}
+	return diskMajor, diskMinor
+}
+
+// cgroupLimit returns the lowest write IOPS limit for the device with
+// the given major and minor numbers set in io.max files of the cgroup
+// with the given path and its ancestors in the cgroup hierarchy mounted
+// at root.
+func cgroupLimit(root, cgroup string, major, minor uint64) (int64, bool) {
+	var limit int64
+	found := false
+	for {
+		data, err := ioutil.ReadFile(filepath.Join(root, cgroup, "io.max"))
+		if err == nil {
+			if n, ok := parseIOMax(data, major, minor); ok && (!found || n < limit) {
+				limit, found = n, true
+			}
+		}
+		parent := filepath.Dir(cgroup)
+		if parent == cgroup || cgroup == "" || cgroup == "." {
+			return limit, found
+		}
+		cgroup = parent
+	}
+}
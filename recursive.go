@@ -0,0 +1,58 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// maxScanDepth is the maximum depth of subdirectories read
+// in recursive mode.
+const maxScanDepth = 4
+
+// Recursive enables or disables recursive mode and returns the same GC.
+//
+// In recursive mode, the collector also collects session files in
+// subdirectories of session directories, up to four levels deep, which
+// supports stores that shard session files, for example:
+//
+//   /path/to/sessions/ab/cd/session_abcdef
+//
+// Files in subdirectories are matched by their names like files in the
+// session directory itself, and session IDs reported for them include
+// the relative path, such as "ab/cd/session_abcdef". Sessions moved to cold
+// storage or trash keep their subdirectories there, so Undo restores them
+// where the store looks for them. Cold storage and trash directories
+// located inside the session directory are not descended into. Strict
+// memory mode doesn't support recursive mode, and Restore doesn't support
+// sessions in subdirectories.
+func (gc *GC) Recursive(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.recursive = enable
+	return gc
+}
+
+// relFileInfo is the information about a file in a subdirectory,
+// with the name relative to the session directory.
+type relFileInfo struct {
+	os.FileInfo
+	name string
+}
+
+func (fi relFileInfo) Name() string { return fi.name }
+
+// isSpecialDir reports whether the directory is the cold storage or the
+// trash directory, which are not descended into in recursive mode.
+func (gc *GC) isSpecialDir(dir string) bool {
+	dir = filepath.Clean(dir)
+	return (gc.coldDir != "" && dir == filepath.Clean(gc.coldDir)) ||
+		(gc.trashDir != "" && dir == filepath.Clean(gc.trashDir))
+}
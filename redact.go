@@ -0,0 +1,77 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RedactIDs sets the function that replaces session IDs before they appear
+// in logs and reports, and returns the same GC. This prevents observability
+// outputs from leaking usable session identifiers. If f is nil, session IDs
+// are not replaced.
+//
+// HashID can be used as such function.
+func (gc *GC) RedactIDs(f func(id string) string) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.redact = f
+	return gc
+}
+
+// HashID returns the first 16 hex characters of SHA-256 hash of the
+// session ID. It can be passed to RedactIDs.
+func HashID(id string) string {
+	h := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(h[:8])
+}
+
+// redactPath replaces the session ID in the path to session file.
+func (gc *GC) redactPath(path string) string {
+	if gc.redact == nil {
+		return path
+	}
+	dir, name := filepath.Split(path)
+	if strings.HasPrefix(name, sessionPrefix) {
+		return dir + sessionPrefix + gc.redact(strings.TrimPrefix(name, sessionPrefix))
+	}
+	if gc.match != nil && gc.match(name) {
+		// The whole name may contain the session ID.
+		return dir + gc.redact(name)
+	}
+	return path
+}
+
+// redactInfo replaces the session ID in the session information.
+func (gc *GC) redactInfo(info SessionInfo) SessionInfo {
+	if gc.redact == nil {
+		return info
+	}
+	info.ID = gc.redact(info.ID)
+	info.Path = gc.redactPath(info.Path)
+	return info
+}
+
+// redactError replaces the session ID in the path of the error.
+func (gc *GC) redactError(err error) error {
+	if gc.redact == nil {
+		return err
+	}
+	switch e := err.(type) {
+	case *os.PathError:
+		return &os.PathError{Op: e.Op, Path: gc.redactPath(e.Path), Err: e.Err}
+	case *os.LinkError:
+		return &os.LinkError{Op: e.Op, Old: gc.redactPath(e.Old), New: gc.redactPath(e.New), Err: e.Err}
+	}
+	return err
+}
@@ -0,0 +1,140 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"time"
+)
+
+// Reason describes why a session was removed.
+type Reason string
+
+// Reasons for removal of sessions.
+const (
+	ReasonAge        Reason = "age"         // session expired
+	ReasonCountQuota Reason = "count-quota" // too many sessions
+	ReasonSizeQuota  Reason = "size-quota"  // session files are too large
+	ReasonRetention  Reason = "retention"   // not retained by daily retention
+	ReasonLifetime   Reason = "lifetime"    // session exceeded max lifetime
+)
+
+// Counts holds the number of session files examined and removed
+// by a collection.
+type Counts struct {
+	Scanned   int // number of session files examined
+	Expired   int // number of expired session files found
+	Removed   int // number of session files removed
+	Vanished  int // number of session files removed by someone else
+	InUse     int // number of expired session files skipped because they were open
+	Failed    int // number of session files that failed to be removed
+	Artifacts int // number of removed store artifacts
+	Pinned    int // number of pinned session files
+
+	Freed int64 // total size of removed session files in bytes
+}
+
+// Report describes the results of a collection.
+type Report struct {
+	Counts
+
+	// RunID is the unique identifier of the collection, which is also
+	// included in its progress updates and log messages. See GC.RunID.
+	RunID string
+
+	// Start is the time when the collection started.
+	Start time.Time
+
+	// Duration is the time the collection took.
+	Duration time.Duration
+
+	// Lag is the time passed since the oldest expired session found
+	// by the collection expired. Large lag means that the collector
+	// doesn't keep up with expiring sessions.
+	Lag time.Duration
+
+	// Created is the estimated number of sessions created since
+	// the previous collection, and CreationRate is their number
+	// per minute. The estimate doesn't include sessions removed by
+	// the store between collections.
+	Created      int
+	CreationRate float64
+
+	// Reasons contains the number of removed session files
+	// by the reason of removal.
+	Reasons map[Reason]int
+
+	// Errors contains errors that happened when removing
+	// individual session files.
+	Errors []error
+
+	// BudgetExceeded is true if the collection stopped removing
+	// sessions because it exceeded the budget.
+	BudgetExceeded bool
+
+	// Skipped is true if the collection was skipped by OnRunStart hook.
+	Skipped bool
+
+	// WarmUp is true if the report is from the warm-up scan,
+	// which doesn't remove anything. See GC.WarmUp.
+	WarmUp bool
+
+	// Clamped is true if a configured max age exceeded the ceiling
+	// and was limited to it. See GC.EnforceCeiling.
+	Clamped bool
+
+	// Anomaly is true if the collection didn't remove anything because
+	// it found unusually many expired sessions. See GC.AnomalyCheck.
+	Anomaly bool
+
+	// Lifetimes describes lifetimes of removed sessions.
+	// See GC.LifetimeAnalytics.
+	Lifetimes Lifetimes
+
+	// SelfCheck is the result of checking random sessions kept by
+	// the collection. See GC.SelfCheck.
+	SelfCheck SelfCheck
+
+	// Tenants contains per-tenant counts in multi-tenant mode.
+	Tenants map[string]Counts
+}
+
+// ErrorCount is the number of occurrences of the same error.
+type ErrorCount struct {
+	Err   error // the first occurrence of the error
+	Count int   // number of occurrences
+}
+
+// ErrorSummary returns errors from the report grouped by their cause,
+// regardless of the file they happened with, in the order of the first
+// occurrence.
+func (r *Report) ErrorSummary() []ErrorCount {
+	var summary []ErrorCount
+	index := make(map[string]int)
+	for _, err := range r.Errors {
+		key := errorCause(err).Error()
+		if i, ok := index[key]; ok {
+			summary[i].Count++
+			continue
+		}
+		index[key] = len(summary)
+		summary = append(summary, ErrorCount{Err: err, Count: 1})
+	}
+	return summary
+}
+
+// errorCause returns the underlying error of path errors.
+func errorCause(err error) error {
+	switch e := err.(type) {
+	case *os.PathError:
+		return e.Err
+	case *os.LinkError:
+		return e.Err
+	}
+	return err
+}
@@ -0,0 +1,77 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"path/filepath"
+	"time"
+)
+
+// RetainDaily enables calendar-style retention of sessions and returns
+// the same GC.
+//
+// All sessions younger than keepAll are kept. Older sessions, until they
+// expire, are thinned out: for each user and each calendar day (in local
+// time) of session file modification, only the most recently modified
+// session is kept, and others are removed. For example, with keepAll set
+// to 24 hours and max age set to 7 days, the collector keeps all sessions
+// from the last day and one session per user per day for a week.
+//
+// The function userID returns the ID of the user the session belongs to,
+// for example, by reading it from the session file. If it returns an empty
+// string, the session is kept until it expires. It is called during
+// collections while the collector is locked, so it must not call methods
+// of GC.
+//
+// Passing nil userID disables retention.
+func (gc *GC) RetainDaily(keepAll time.Duration, userID func(SessionInfo) string) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.keepAll = keepAll
+	gc.userID = userID
+	return gc
+}
+
+// retainDaily splits live session files into retained ones and the ones
+// that must be removed according to the daily retention.
+func (gc *GC) retainDaily(tenant string, files []sessionFile, now time.Time) (keep, remove []sessionFile) {
+	type userDay struct {
+		user string
+		y    int
+		m    time.Month
+		d    int
+	}
+	newest := make(map[userDay]int) // index in keep
+	for _, f := range files {
+		if now.Sub(f.fi.ModTime()) <= gc.keepAll {
+			keep = append(keep, f)
+			continue
+		}
+		user := gc.userID(newSessionInfo(tenant, filepath.Dir(f.path), f.fi, false))
+		if user == "" {
+			keep = append(keep, f)
+			continue
+		}
+		y, m, d := f.fi.ModTime().Date()
+		key := userDay{user, y, m, d}
+		i, ok := newest[key]
+		if !ok {
+			newest[key] = len(keep)
+			keep = append(keep, f)
+			continue
+		}
+		older := f
+		if f.fi.ModTime().After(keep[i].fi.ModTime()) {
+			older, keep[i] = keep[i], f
+		}
+		older.reason = ReasonRetention
+		remove = append(remove, older)
+	}
+	return keep, remove
+}
@@ -0,0 +1,61 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "time"
+
+// RetryFailed sets the number of times the collector retries removing
+// session files that failed to be removed, and the time to wait before
+// each retry, and returns the same GC.
+//
+// Failed files are retried together after the collector finishes
+// removing sessions from a directory, which helps with transient errors,
+// for example, on network filesystems. Only the errors of the last
+// attempt are counted and reported. Retries are not performed in strict
+// memory mode.
+func (gc *GC) RetryFailed(n int, wait time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.retries = n
+	gc.retryWait = wait
+	return gc
+}
+
+// deferFailure reports whether the failed removal should be retried
+// later instead of being counted.
+func (gc *GC) deferFailure(f sessionFile) bool {
+	if gc.retryAttempt >= gc.retries || gc.strictMemory {
+		return false
+	}
+	gc.failedFiles = append(gc.failedFiles, f)
+	return true
+}
+
+// startRetries prepares for retrying failed removals from a directory.
+func (gc *GC) startRetries() {
+	gc.retryAttempt = gc.retries
+	gc.failedFiles = nil
+}
+
+// retryFailed retries removing session files that failed to be removed
+// from the scanned directory. Failures after it returns, such as the ones
+// found by verification, are counted right away.
+func (gc *GC) retryFailed(s *dirScan) {
+	for gc.retryAttempt < gc.retries && len(gc.failedFiles) > 0 {
+		failed := gc.failedFiles
+		gc.failedFiles = nil
+		gc.retryAttempt++
+		time.Sleep(gc.retryWait)
+		for _, f := range failed {
+			gc.countRemoval(&s.counts, f, gc.discard(s.tenant, f))
+		}
+	}
+	gc.retryAttempt = gc.retries
+	gc.failedFiles = nil
+}
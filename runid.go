@@ -0,0 +1,50 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// newRunID returns a new random collection identifier.
+func newRunID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Unlikely, but IDs only need to be unique enough to tell
+		// collections apart.
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(b[:])
+}
+
+// RunID returns the identifier of the in-progress collection, or an empty
+// string if no collection is in progress. The same identifier is in the
+// collection report (see Report.RunID), progress updates, and log messages.
+//
+// Unlike other methods, it may be called from the function set by
+// OnDelete, for example, to correlate audit events with the collection
+// that removed the files.
+func (gc *GC) RunID() string {
+	id, _ := gc.runID.Load().(string)
+	return id
+}
+
+// startRun assigns a new identifier to the in-progress collection.
+func (gc *GC) startRun() string {
+	id := newRunID()
+	gc.runID.Store(id)
+	return id
+}
+
+// finishRun clears the identifier of the finished collection.
+func (gc *GC) finishRun() {
+	gc.runID.Store("")
+}
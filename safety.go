@@ -0,0 +1,103 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrUnsafeFirstRun is returned by a collection that was refused by the
+// first run safety check. See FirstRunSafety.
+var ErrUnsafeFirstRun = errors.New("fsgc: first collection would remove too many sessions")
+
+// FirstRunSafety enables the first run safety check and returns the same
+// GC.
+//
+// Before the first collection, the collector counts sessions that would
+// expire, and if their fraction of all sessions is greater than max,
+// it calls confirm with the numbers of scanned and expired sessions.
+// Unless confirm returns true, nothing is removed and the collection
+// fails with ErrUnsafeFirstRun. The check is repeated by the following
+// collections until one of them passes it. This guards against
+// misconfiguration, such as setting max age to one hour for a directory
+// of week-old, but valid sessions.
+//
+// If confirm is nil, collections are refused. If max is zero, the check
+// is disabled. If statistics loaded from the state file (see StateFile)
+// show that the collector has already run, the check is not performed.
+//
+// The function confirm is called in the middle of the collection,
+// so it must not call methods of GC.
+func (gc *GC) FirstRunSafety(max float64, confirm func(scanned, expired int) bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.firstRunMax = max
+	gc.firstRunConfirm = confirm
+	return gc
+}
+
+// checkFirstRun performs the first run safety check. If the collection
+// is refused, it returns the report with the counts and an error.
+func (gc *GC) checkFirstRun(now time.Time) (Report, error) {
+	var r Report
+	if gc.firstRunMax <= 0 || gc.firstRunDone || gc.stats.load().Runs > 0 {
+		return r, nil
+	}
+	dirs, err := gc.sessionDirs()
+	if err != nil {
+		return r, err
+	}
+	for _, d := range dirs {
+		if err := gc.countExpired(d, now, &r.Counts); err != nil {
+			return r, err
+		}
+	}
+	if r.Scanned == 0 || float64(r.Expired)/float64(r.Scanned) <= gc.firstRunMax ||
+		(gc.firstRunConfirm != nil && gc.firstRunConfirm(r.Scanned, r.Expired)) {
+		gc.firstRunDone = true
+		return Report{}, nil
+	}
+	return r, ErrUnsafeFirstRun
+}
+
+// countExpired counts sessions and expired sessions in the directory,
+// reading it in batches.
+func (gc *GC) countExpired(d sessionDir, now time.Time, c *Counts) error {
+	f, err := os.Open(d.path)
+	if err != nil {
+		if d.cold && os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+	p := gc.policyFor(d.tenant)
+	for {
+		fis, err := f.Readdir(strictBatch)
+		for _, fi := range fis {
+			if fi.IsDir() || !gc.isSession(fi.Name()) {
+				continue
+			}
+			c.Scanned++
+			if reason, _ := gc.expiryReason(filepath.Join(d.path, fi.Name()), fi, p, now); reason != "" {
+				c.Expired++
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
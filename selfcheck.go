@@ -0,0 +1,85 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"math/rand"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// SelfCheck is the result of checking sessions kept by a collection.
+// See GC.SelfCheck.
+type SelfCheck struct {
+	Sampled int           // number of checked sessions
+	Failed  []SessionInfo // kept sessions older than max age (see RedactIDs)
+}
+
+// SelfCheck enables checking of n random sessions kept by each collection,
+// and returns the same GC. Zero disables it, which is the default.
+//
+// After removing expired sessions, the collector examines the files of
+// randomly chosen kept sessions again and verifies that they are younger
+// than the max age, recording the result in Report.SelfCheck. Failures
+// indicate bugs, for example, in the clock or in the custom matcher,
+// which would otherwise go unnoticed. Sessions with an expiry time set
+// by SetExpiry, and all sessions if ExpireFunc, SessionMaxAge, or
+// ExpireByAccess is set, are not checked, since they don't expire by
+// the modification time and the policy max age. The check is not done
+// in strict memory mode.
+func (gc *GC) SelfCheck(n int) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.selfCheckN = n
+	return gc
+}
+
+// selfCheck checks random sessions kept by the scans.
+func (gc *GC) selfCheck(scans []*dirScan, now time.Time) SelfCheck {
+	var c SelfCheck
+	if gc.selfCheckN <= 0 || gc.expireFunc != nil || gc.sessionMaxAge != nil || gc.byAccess {
+		return c
+	}
+	// Choose sessions with reservoir sampling.
+	type sample struct {
+		s *dirScan
+		f sessionFile
+	}
+	var samples []sample
+	seen := 0
+	for _, s := range scans {
+		if s.err != nil {
+			continue
+		}
+		for _, f := range s.live {
+			seen++
+			if len(samples) < gc.selfCheckN {
+				samples = append(samples, sample{s, f})
+			} else if i := rand.Intn(seen); i < len(samples) {
+				samples[i] = sample{s, f}
+			}
+		}
+	}
+	for _, sm := range samples {
+		fi, err := os.Lstat(sm.f.path)
+		if err != nil {
+			continue // removed by quota or the store
+		}
+		if gc.xattrs && !readXattrMeta(sm.f.path).expires.IsZero() {
+			continue
+		}
+		c.Sampled++
+		if now.Sub(fi.ModTime()) > sm.s.policy.MaxAge {
+			info := newSessionInfo(sm.s.tenant, filepath.Dir(sm.f.path), fi, true)
+			c.Failed = append(c.Failed, gc.redactInfo(info))
+		}
+	}
+	return c
+}
@@ -0,0 +1,89 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SessionInfo describes a session file.
+//
+// It is used by all APIs that report information about individual
+// sessions.
+type SessionInfo struct {
+	ID      string    // session ID (file name without prefix)
+	Path    string    // path to session file
+	Tenant  string    // tenant name in multi-tenant mode
+	Size    int64     // file size in bytes
+	ModTime time.Time // file modification time
+	Expired bool      // whether the session is expired
+	Reason  Reason    // why the session is removed, if it is
+}
+
+func newSessionInfo(tenant, dir string, fi os.FileInfo, expired bool) SessionInfo {
+	return SessionInfo{
+		ID:      strings.TrimPrefix(fi.Name(), sessionPrefix),
+		Path:    filepath.Join(dir, fi.Name()),
+		Tenant:  tenant,
+		Size:    fi.Size(),
+		ModTime: fi.ModTime(),
+		Expired: expired,
+	}
+}
+
+// List returns information about all sessions, including
+// sessions in cold storage. It doesn't remove anything.
+func (gc *GC) List() ([]SessionInfo, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.sessionsAt(gc.now(), false)
+}
+
+// ExpiredAt returns information about sessions that would be expired
+// at the given time. It doesn't remove anything.
+//
+// Passing a future time tells which sessions will be removed by
+// collections happening by that time.
+func (gc *GC) ExpiredAt(t time.Time) ([]SessionInfo, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	return gc.sessionsAt(t, true)
+}
+
+// sessionsAt returns information about sessions, with expiration
+// evaluated at the given time as by collections. If expiredOnly is true,
+// it returns only expired sessions.
+func (gc *GC) sessionsAt(t time.Time, expiredOnly bool) ([]SessionInfo, error) {
+	dirs, err := gc.sessionDirs()
+	if err != nil {
+		return nil, err
+	}
+	var sessions []SessionInfo
+	for _, d := range dirs {
+		fis, err := gc.readSessions(d.path)
+		if err != nil {
+			if d.cold && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		p := gc.policyFor(d.tenant)
+		for _, fi := range fis {
+			reason, _ := gc.expiryReason(filepath.Join(d.path, fi.Name()), fi, p, t)
+			if reason != "" || !expiredOnly {
+				info := newSessionInfo(d.tenant, d.path, fi, reason != "")
+				info.Reason = reason
+				sessions = append(sessions, info)
+			}
+		}
+	}
+	return sessions, nil
+}
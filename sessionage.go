@@ -0,0 +1,76 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"io/ioutil"
+	"time"
+)
+
+// SessionMaxAge sets the function returning the max age of the session
+// from the content of its file, and returns the same GC. If f is nil,
+// the max age of the collector is used for all sessions.
+//
+// Applications using different max ages per session, for example, a long
+// one for "remember me" sessions, can store it in session values and
+// decode it with the store codecs:
+//
+//   gc.SessionMaxAge(func(data []byte) (time.Duration, bool) {
+//           values := make(map[interface{}]interface{})
+//           if err := securecookie.DecodeMulti("session-name", string(data), &values, store.Codecs...); err != nil {
+//                   return 0, false
+//           }
+//           secs, ok := values["max_age"].(int)
+//           return time.Duration(secs) * time.Second, ok
+//   })
+//
+// If the function returns false, the session expires according to the
+// policy, as usual. The returned max age counts from the last access
+// time in access time mode (see ExpireByAccess). The max age ceiling (see EnforceCeiling) still applies.
+// Since this requires reading every session file during each collection,
+// collections become slower. Lag reports still use the policy max age,
+// and in precise mode, sessions kept past it are left to collections
+// scheduled by the interval. The function may be called concurrently from
+// collections and other methods.
+func (gc *GC) SessionMaxAge(f func(data []byte) (time.Duration, bool)) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.sessionMaxAge = f
+	return gc
+}
+
+// ownMaxAge returns the max age of the session file returned by the
+// function set by SessionMaxAge, limited by the ceiling. If the function
+// is not set, the session can't be read, or the function doesn't return
+// the max age, ok is false.
+func (gc *GC) ownMaxAge(path string) (d time.Duration, ok bool) {
+	if gc.sessionMaxAge == nil {
+		return 0, false
+	}
+	data, err := readSession(path)
+	if err != nil {
+		return 0, false
+	}
+	if d, ok = gc.sessionMaxAge(data); !ok {
+		return 0, false
+	}
+	return gc.clampMaxAge(d), true
+}
+
+// readSession returns the content of the session file, without updating
+// its access time if possible, so that reading it doesn't keep the session
+// alive in access time mode.
+func readSession(path string) ([]byte, error) {
+	f, err := openNoatime(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
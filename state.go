@@ -0,0 +1,232 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// Stats contains cumulative statistics of the collector.
+type Stats struct {
+	Runs    int64     `json:"runs"`     // number of collections
+	Scanned int64     `json:"scanned"`  // number of examined session files
+	Removed int64     `json:"removed"`  // number of removed session files
+	LastRun time.Time `json:"last_run"` // time of the last collection
+}
+
+// MarshalJSON returns the JSON encoding of statistics, including
+// the schema version (see ReportVersion).
+func (st Stats) MarshalJSON() ([]byte, error) {
+	type stats Stats // without methods
+	return json.Marshal(struct {
+		Version int `json:"version"`
+		stats
+	}{ReportVersion, stats(st)})
+}
+
+// statCounters holds cumulative statistics updated atomically,
+// so that they can be read without waiting for a collection.
+type statCounters struct {
+	runs    int64
+	scanned int64
+	removed int64
+	lastRun int64 // Unix time in nanoseconds
+
+	// Inputs for Pressure as float64 bits.
+	backlog  uint64
+	lagRatio uint64
+
+	creationRate uint64 // float64 bits
+
+	started int64 // Unix time in nanoseconds when started, zero if stopped
+	nextRun int64 // Unix time in nanoseconds of the next collection
+}
+
+func (c *statCounters) load() Stats {
+	st := Stats{
+		Runs:    atomic.LoadInt64(&c.runs),
+		Scanned: atomic.LoadInt64(&c.scanned),
+		Removed: atomic.LoadInt64(&c.removed),
+	}
+	if t := atomic.LoadInt64(&c.lastRun); t != 0 {
+		st.LastRun = time.Unix(0, t)
+	}
+	return st
+}
+
+func (c *statCounters) store(st Stats) {
+	atomic.StoreInt64(&c.runs, st.Runs)
+	atomic.StoreInt64(&c.scanned, st.Scanned)
+	atomic.StoreInt64(&c.removed, st.Removed)
+	var t int64
+	if !st.LastRun.IsZero() {
+		t = st.LastRun.UnixNano()
+	}
+	atomic.StoreInt64(&c.lastRun, t)
+}
+
+// Stats returns cumulative statistics of the collector.
+//
+// It doesn't wait for an in-progress collection, so it is cheap to call
+// frequently, for example, from metrics handlers.
+func (gc *GC) Stats() Stats {
+	return gc.stats.load()
+}
+
+// State describes the current state of the collector.
+type State struct {
+	Running    bool      // whether background collections are started
+	Started    time.Time // when background collections were started
+	Collecting bool      // whether a collection is in progress
+	RunID      string    // identifier of the in-progress collection
+}
+
+// Running reports whether background collections are started,
+// that is, Start was called and Stop wasn't called since then.
+//
+// Like Stats, it doesn't wait for an in-progress collection, so it can
+// be used by health checks.
+func (gc *GC) Running() bool {
+	return atomic.LoadInt64(&gc.stats.started) != 0
+}
+
+// State returns the current state of the collector. It doesn't wait for
+// an in-progress collection.
+func (gc *GC) State() State {
+	var st State
+	if t := atomic.LoadInt64(&gc.stats.started); t != 0 {
+		st.Running = true
+		st.Started = time.Unix(0, t)
+	}
+	st.RunID = gc.RunID()
+	st.Collecting = st.RunID != ""
+	return st
+}
+
+// result holds the error of a collection, so that nil errors can be
+// stored in atomic.Value.
+type result struct {
+	err error
+}
+
+// LastRun returns the time of the last collection, or zero time if there
+// were no collections. It is the same as Stats().LastRun.
+func (gc *GC) LastRun() time.Time {
+	return gc.Stats().LastRun
+}
+
+// LastResult returns the error of the last collection since the collector
+// was created, or nil if it succeeded or there were no collections.
+// Skipped collections don't change it.
+//
+// Like Stats, it doesn't wait for an in-progress collection.
+func (gc *GC) LastResult() error {
+	r, _ := gc.lastResult.Load().(result)
+	return r.err
+}
+
+// NextRun returns the time of the next scheduled background collection,
+// or zero time if the collector is not started. While a collection is in
+// progress, it returns the time it was scheduled for.
+//
+// Like Stats, it doesn't wait for an in-progress collection.
+func (gc *GC) NextRun() time.Time {
+	if t := atomic.LoadInt64(&gc.stats.nextRun); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
+
+// setNextRun sets the time of the next background collection.
+func (gc *GC) setNextRun(t time.Time) {
+	atomic.StoreInt64(&gc.stats.nextRun, t.UnixNano())
+}
+
+// StateFile sets the path to the file where the collector keeps
+// its statistics between restarts, loads statistics from it, and returns
+// the same GC.
+//
+// The file is replaced atomically after each collection. If it is corrupt,
+// for example, after a crash, the statistics are reset instead of failing
+// collections.
+func (gc *GC) StateFile(path string) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.stateFile = path
+	if path != "" {
+		gc.loadState()
+	}
+	return gc
+}
+
+// loadState loads statistics from the state file.
+func (gc *GC) loadState() {
+	var st Stats
+	if b, err := ioutil.ReadFile(gc.stateFile); err == nil {
+		if err := json.Unmarshal(b, &st); err != nil {
+			// Corrupt or partially written state, start over.
+			st = Stats{}
+		}
+	}
+	gc.stats.store(st)
+}
+
+// updateState updates statistics with the collection report
+// and saves them to the state file, if it is set.
+func (gc *GC) updateState(r Report) error {
+	atomic.AddInt64(&gc.stats.runs, 1)
+	atomic.AddInt64(&gc.stats.scanned, int64(r.Scanned))
+	atomic.AddInt64(&gc.stats.removed, int64(r.Removed))
+	atomic.StoreInt64(&gc.stats.lastRun, time.Now().UnixNano())
+	if gc.stateFile == "" {
+		return nil
+	}
+	b, err := json.Marshal(gc.stats.load())
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(gc.stateFile, b, 0600)
+}
+
+// writeFileAtomic writes data to a temporary file, syncs it, and then
+// renames it to the given name, so that the file either has the old
+// or the new content even after a crash.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(name)
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".tmp")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(perm)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	// Sync directory to persist the rename.
+	syncDir(dir) // ignore errors
+	return nil
+}
@@ -0,0 +1,61 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "time"
+
+// SyncWithStore makes the running collector periodically update its max age
+// from the store options, and returns the same GC.
+//
+// The function maxAge must return the max age of sessions in seconds,
+// as set in the store options. It is called when the collector starts and
+// then every given period. Non-positive values are ignored. For example:
+//
+//   store := sessions.NewFilesystemStore(path, []byte("secret"))
+//   gc := fsgc.New(path).SyncWithStore(func() int {
+//           return store.Options.MaxAge
+//   }, time.Minute)
+//
+// The function is called on the collector goroutine, so if the options are
+// changed at runtime, access to them must be synchronized.
+//
+// Changes take effect when the collector is started. Passing nil
+// disables synchronization.
+func (gc *GC) SyncWithStore(maxAge func() int, every time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.syncMaxAgeFunc = maxAge
+	gc.syncEvery = every
+	return gc
+}
+
+// storeSync returns the function for getting max age from the store
+// and the period of synchronization.
+func (gc *GC) storeSync() (func() int, time.Duration) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.syncEvery <= 0 {
+		return nil, 0
+	}
+	return gc.syncMaxAgeFunc, gc.syncEvery
+}
+
+// syncMaxAge updates max age with the value returned by f.
+func (gc *GC) syncMaxAge(f func() int) {
+	if f == nil {
+		return
+	}
+	if secs := f(); secs > 0 {
+		// Not using MaxAge, which panics if the configuration is frozen.
+		gc.mu.Lock()
+		gc.maxAge = time.Duration(secs) * time.Second
+		gc.rescheduleLocked()
+		gc.mu.Unlock()
+	}
+}
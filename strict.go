@@ -0,0 +1,170 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// strictBatch is the number of directory entries read at once
+// in strict memory mode.
+const strictBatch = 1024
+
+// StrictMemory enables or disables strict memory mode and returns the same
+// GC.
+//
+// In strict memory mode, the collector reads session directories in small
+// batches and removes expired sessions as it finds them, so that its memory
+// use doesn't depend on the number of session files. This is useful for
+// directories with tens of millions of files and for memory-constrained
+// environments.
+//
+// Features that need to know about all sessions at once are disabled in
+// this mode: removal order, per-tenant urgency ordering, policy quotas
+// (MaxSessions and MaxBytes), daily retention, verification of removals,
+// and removal of orphaned creation time sidecars. Removing files while reading a directory may
+// cause some expired sessions to be missed; they are removed by the next
+// collection.
+func (gc *GC) StrictMemory(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.strictMemory = enable
+	return gc
+}
+
+// streamSweep removes expired sessions from all session directories
+// without keeping lists of session files in memory.
+func (gc *GC) streamSweep(now, start, deadline time.Time) (Report, error) {
+	var r Report
+	gc.startProgress(start, 0)
+	if !gc.tenants && len(gc.extraDirs) == 0 {
+		var err error
+		r.BudgetExceeded, err = gc.streamDir("", gc.dir, gc.coldDir, gc.policyFor(""), now, deadline, &r.Counts)
+		return r, err
+	}
+	dirs, err := gc.tenantDirs()
+	if err != nil {
+		return r, err
+	}
+	r.Tenants = make(map[string]Counts, len(dirs))
+	var firstErr error
+	for _, d := range dirs {
+		var c Counts
+		if !r.BudgetExceeded && !gc.canceled() {
+			var err error
+			r.BudgetExceeded, err = gc.streamDir(d.tenant, d.path, d.coldDir, gc.policyFor(d.tenant), now, deadline, &c)
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		r.Tenants[d.tenant] = c
+		r.Scanned += c.Scanned
+		r.Expired += c.Expired
+		r.Removed += c.Removed
+		r.Vanished += c.Vanished
+		r.InUse += c.InUse
+		r.Failed += c.Failed
+		r.Artifacts += c.Artifacts
+		r.Pinned += c.Pinned
+		r.Freed += c.Freed
+	}
+	return r, firstErr
+}
+
+// streamDir removes expired sessions from the session directory and its
+// cold directory, reading them in batches. It returns true if it stopped
+// because the deadline has passed.
+func (gc *GC) streamDir(tenant, dir, coldDir string, p Policy, now, deadline time.Time, c *Counts) (bool, error) {
+	var open map[string]bool
+	if gc.skipOpen {
+		open = openFiles() // ignore errors
+	}
+	s := &dirScan{tenant: tenant, policy: p}
+	defer func() { *c = s.counts }()
+	defer gc.flushBatch()
+	process := func(dir string, cold bool) (bool, error) {
+		f, err := os.Open(dir)
+		if err != nil {
+			if cold && os.IsNotExist(err) {
+				return false, nil
+			}
+			return false, err
+		}
+		defer f.Close()
+		for {
+			fis, err := f.Readdir(strictBatch)
+			for _, fi := range fis {
+				if gc.canceled() {
+					return false, nil
+				}
+				if !deadline.IsZero() && time.Now().After(deadline) {
+					return true, nil
+				}
+				if fi.IsDir() {
+					continue
+				}
+				name := filepath.Join(dir, fi.Name())
+				switch {
+				case gc.isArtifact(fi.Name()):
+					if now.Sub(fi.ModTime()) > gc.artifactAge {
+						// Ignore errors.
+						if removeSession(name, fi) == nil {
+							s.counts.Artifacts++
+						}
+					}
+					continue
+				case !gc.isSession(fi.Name()):
+					continue
+				}
+				sf := sessionFile{path: name, fi: fi}
+				if !cold && coldDir != "" && !gc.isExpired(p, fi, now) && now.Sub(fi.ModTime()) > gc.coldAfter {
+					// Session file is stale, move it to cold storage.
+					// Ignore errors.
+					coldName := filepath.Join(coldDir, fi.Name())
+					os.MkdirAll(coldDir, 0700)
+					if os.Rename(name, coldName) == nil {
+						os.Rename(name+sidecarExt, coldName+sidecarExt)
+						sf.path = coldName
+					}
+				}
+				s.add(sf, gc, now)
+				if len(s.expired) == 0 {
+					continue
+				}
+				sf = s.expired[0]
+				s.expired = s.expired[:0]
+				if open != nil && isOpen(open, sf.path) {
+					s.counts.InUse++
+					gc.keepLag(sf)
+					gc.advanceProgress(false)
+					continue
+				}
+				gc.countRemoval(&s.counts, sf, gc.discard(tenant, sf))
+			}
+			s.live = s.live[:0]
+			if err == io.EOF {
+				return false, nil
+			}
+			if err != nil {
+				return false, err
+			}
+		}
+	}
+	if coldDir != "" {
+		// Process cold storage first, so that sessions moved there
+		// during this collection are not examined twice.
+		if stopped, err := process(coldDir, true); stopped || err != nil {
+			return stopped, err
+		}
+	}
+	return process(dir, false)
+}
@@ -0,0 +1,300 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Tenants enables or disables multi-tenant mode and returns the same GC.
+//
+// In multi-tenant mode, each subdirectory of the collector directory is
+// a separate session directory belonging to a tenant named after it:
+//
+//   /path/to/sessions/<tenant>/session_*
+//
+// Tenant directories, except for the cold storage and trash directories,
+// are discovered on every collection, and reports contain per-tenant
+// counts. If cold storage is enabled, stale sessions of each tenant are
+// moved into a subdirectory of the cold directory with the same name.
+func (gc *GC) Tenants(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.tenants = enable
+	return gc
+}
+
+// readTenants returns the names of tenant directories.
+func (gc *GC) readTenants() ([]string, error) {
+	f, err := os.Open(gc.dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fis, err := f.Readdir(0)
+	if err != nil {
+		return nil, err
+	}
+	coldDir := filepath.Clean(gc.coldDir)
+	trashDir := filepath.Clean(gc.trashDir)
+	var tenants []string
+	for _, fi := range fis {
+		if !fi.IsDir() {
+			continue
+		}
+		if p := filepath.Join(gc.dir, fi.Name()); p == coldDir || p == trashDir {
+			continue // cold storage and trash are not tenants
+		}
+		tenants = append(tenants, fi.Name())
+	}
+	return tenants, nil
+}
+
+// AddDir adds a session directory collected in addition to the collector
+// directory, and returns the same GC. This way, a single collector can
+// clean up after several FilesystemStores instead of running a collector
+// per directory.
+//
+// Each added directory is collected like a tenant directory: its counts
+// are reported in Report.Tenants and its policy can be set by TenantPolicy,
+// with the directory path used as the tenant name. Cold storage and trash
+// don't apply to added directories: sessions are removed from them
+// permanently. Added directories must not overlap with the collector
+// directory or with each other.
+func (gc *GC) AddDir(path string) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.extraDirs = append(gc.extraDirs, path)
+	return gc
+}
+
+// isExtraDir reports whether the tenant is a directory added by AddDir.
+func (gc *GC) isExtraDir(tenant string) bool {
+	for _, d := range gc.extraDirs {
+		if d == tenant {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantPath returns the path to the directory of the tenant.
+func (gc *GC) tenantPath(tenant string) string {
+	if gc.isExtraDir(tenant) {
+		return tenant
+	}
+	return filepath.Join(gc.dir, tenant)
+}
+
+// tenantDir describes a directory collected as a unit.
+type tenantDir struct {
+	tenant  string
+	path    string
+	coldDir string // cold storage directory, if enabled
+}
+
+// tenantDirs returns the directories collected as units: the collector
+// directory or tenant directories in multi-tenant mode, followed by
+// added directories.
+func (gc *GC) tenantDirs() ([]tenantDir, error) {
+	var dirs []tenantDir
+	if gc.tenants {
+		tenants, err := gc.readTenants()
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tenants {
+			d := tenantDir{tenant: t, path: filepath.Join(gc.dir, t)}
+			if gc.coldDir != "" {
+				d.coldDir = filepath.Join(gc.coldDir, t)
+			}
+			dirs = append(dirs, d)
+		}
+	} else {
+		dirs = append(dirs, tenantDir{path: gc.dir, coldDir: gc.coldDir})
+	}
+	for _, d := range gc.extraDirs {
+		dirs = append(dirs, tenantDir{tenant: d, path: d})
+	}
+	return dirs, nil
+}
+
+// sessionDir describes a directory containing session files.
+type sessionDir struct {
+	tenant string
+	path   string
+	cold   bool
+}
+
+// sessionDirs returns all directories containing session files,
+// including cold storage directories.
+func (gc *GC) sessionDirs() ([]sessionDir, error) {
+	tenants := []string{""}
+	if gc.tenants {
+		var err error
+		if tenants, err = gc.readTenants(); err != nil {
+			return nil, err
+		}
+	}
+	var dirs []sessionDir
+	for _, t := range tenants {
+		dirs = append(dirs, sessionDir{tenant: t, path: filepath.Join(gc.dir, t)})
+		if gc.coldDir != "" {
+			dirs = append(dirs, sessionDir{tenant: t, path: filepath.Join(gc.coldDir, t), cold: true})
+		}
+	}
+	for _, d := range gc.extraDirs {
+		dirs = append(dirs, sessionDir{tenant: d, path: d})
+	}
+	return dirs, nil
+}
+
+// Policy describes how sessions are collected.
+type Policy struct {
+	// MaxAge is the max age of sessions. If zero, the max age
+	// of the collector is used.
+	MaxAge time.Duration
+
+	// MaxSessions is the maximum number of sessions. If there are
+	// more sessions, the oldest ones are removed. Zero means no limit.
+	MaxSessions int
+
+	// MaxBytes is the maximum total size of session files in bytes.
+	// If session files take more space, the oldest ones are removed.
+	// Zero means no limit.
+	MaxBytes int64
+}
+
+// isExpired reports whether the session file is expired at the given time.
+func (p Policy) isExpired(fi os.FileInfo, now time.Time) bool {
+	return now.Sub(fi.ModTime()) > p.MaxAge
+}
+
+// ExpireFunc sets the function deciding whether the session file is
+// expired at the given time, and returns the same GC. It replaces
+// comparing the file modification time with the policy max age, so that
+// the decision can be based on anything, such as the file size or the
+// time embedded in its name. If f is nil, max age is used.
+//
+// Quotas, max lifetime, and expiry times set with SetExpiry still apply.
+// The function may be called concurrently from collections and other
+// methods.
+func (gc *GC) ExpireFunc(f func(fi os.FileInfo, now time.Time) bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.expireFunc = f
+	return gc
+}
+
+// isExpired reports whether the session file is expired at the given
+// time according to the policy or the function set by ExpireFunc.
+func (gc *GC) isExpired(p Policy, fi os.FileInfo, now time.Time) bool {
+	if gc.expireFunc != nil {
+		if gc.ceiling > 0 && now.Sub(fi.ModTime()) > gc.ceiling {
+			return true
+		}
+		return gc.expireFunc(fi, now)
+	}
+	if gc.byAccess {
+		return now.Sub(gc.lastUsed(fi)) > p.MaxAge
+	}
+	return p.isExpired(fi, now)
+}
+
+// TenantPolicy sets the policy for the given tenant in multi-tenant mode,
+// overriding the collector settings, and returns the same GC.
+func (gc *GC) TenantPolicy(tenant string, p Policy) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	if gc.policies == nil {
+		gc.policies = make(map[string]Policy)
+	}
+	gc.policies[tenant] = p
+	return gc
+}
+
+// policyFor returns the policy for the given tenant.
+func (gc *GC) policyFor(tenant string) Policy {
+	p := gc.policies[tenant]
+	if p.MaxAge == 0 {
+		p.MaxAge = gc.maxAge
+	}
+	p = gc.applyMemoryFSPolicy(p, gc.tenantPath(tenant))
+	p.MaxAge = gc.clampMaxAge(p.MaxAge)
+	return p
+}
+
+// sessionFile describes a session file found by the collector.
+type sessionFile struct {
+	tenant string
+	path   string
+	fi     os.FileInfo
+	reason Reason        // why the file is removed
+	lag    time.Duration // time passed since the file expired
+}
+
+// enforceQuota removes the oldest sessions until the remaining ones fit
+// into the policy quotas, and updates counts.
+func (gc *GC) enforceQuota(tenant string, files []sessionFile, p Policy, c *Counts) {
+	for _, f := range overQuota(files, p) {
+		gc.countRemoval(c, f, gc.discard(tenant, f))
+	}
+}
+
+// overQuota returns the oldest sessions that must be removed for
+// the remaining ones to fit into the policy quotas.
+func overQuota(files []sessionFile, p Policy) []sessionFile {
+	if p.MaxSessions <= 0 && p.MaxBytes <= 0 {
+		return nil
+	}
+	sortSessions(files, Oldest)
+	n := len(files)
+	var size int64
+	for _, f := range files {
+		size += f.fi.Size()
+	}
+	for i, f := range files {
+		switch {
+		case p.MaxSessions > 0 && n > p.MaxSessions:
+			files[i].reason = ReasonCountQuota
+		case p.MaxBytes > 0 && size > p.MaxBytes:
+			files[i].reason = ReasonSizeQuota
+		default:
+			return files[:i]
+		}
+		n--
+		size -= f.fi.Size()
+	}
+	return files
+}
+
+// victims returns session files that would be removed according
+// to the policy: expired ones in the removal order, followed by
+// the ones exceeding the quotas. Pinned session files are never removed.
+func (gc *GC) victims(files []sessionFile, p Policy, now time.Time) []sessionFile {
+	var expired, live []sessionFile
+	for _, f := range files {
+		var pinned bool
+		f.reason, pinned = gc.expiryReason(f.path, f.fi, p, now)
+		switch {
+		case pinned:
+		case f.reason != "":
+			expired = append(expired, f)
+		default:
+			live = append(live, f)
+		}
+	}
+	sortSessions(expired, gc.order)
+	return append(expired, overQuota(live, p)...)
+}
@@ -0,0 +1,217 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// DefaultTrashMaxAge is the default time for which sessions are kept
+// in trash in soft-delete mode.
+const DefaultTrashMaxAge = 24 * time.Hour
+
+// trashTimeFormat is the format of names of trash subdirectories.
+const trashTimeFormat = "20060102T150405.000000000Z"
+
+// Trash enables soft-delete mode and returns the same GC.
+//
+// In soft-delete mode, instead of removing session files, the collector
+// moves them into a subdirectory of the trash directory named after the
+// time of collection, from where they can be restored by calling Undo.
+// Tenant subdirectories in multi-tenant mode and shard subdirectories
+// in recursive mode are kept. Creation times recorded by RecordCreation
+// are moved and restored together with the sessions.
+//
+// Sessions are kept in trash for DefaultTrashMaxAge, and then removed
+// by the collector. To set a different time, call TrashMaxAge.
+//
+// The trash directory must be on the same filesystem as the session
+// directory. If dir is empty, soft-delete mode is disabled.
+func (gc *GC) Trash(dir string) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.trashDir = dir
+	return gc
+}
+
+// TrashMaxAge sets the time for which sessions are kept in trash
+// in soft-delete mode, and returns the same GC. Zero means forever.
+func (gc *GC) TrashMaxAge(d time.Duration) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.trashMaxAge = d
+	return gc
+}
+
+// expireTrash removes trash subdirectories of collections that happened
+// earlier than the trash max age ago.
+func (gc *GC) expireTrash() {
+	if gc.trashDir == "" || gc.trashMaxAge <= 0 {
+		return
+	}
+	runs, err := readDirNames(gc.trashDir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	for _, run := range runs {
+		t, err := time.Parse(trashTimeFormat, run)
+		if err != nil || now.Sub(t) <= gc.trashMaxAge {
+			continue
+		}
+		os.RemoveAll(filepath.Join(gc.trashDir, run)) // ignore errors
+	}
+}
+
+// discard removes the session file found in the tenant directory,
+// or moves it to trash in soft-delete mode, respecting the removal rate limit.
+// The creation time sidecar is moved to trash together with the session.
+func (gc *GC) discard(tenant string, f sessionFile) error {
+	gc.pace()
+	dst, ok := gc.trashPath(tenant, f)
+	if !ok {
+		return gc.unlink(f.path, f.fi)
+	}
+	if err := checkUnchanged(f.path, f.fi); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+		return err
+	}
+	if err := os.Rename(f.path, dst); err != nil {
+		return err
+	}
+	os.Rename(f.path+sidecarExt, dst+sidecarExt) // ignore errors
+	return nil
+}
+
+// trashPath returns the path to which the session file found in the tenant
+// directory is moved by discard. If the session is removed permanently,
+// ok is false.
+func (gc *GC) trashPath(tenant string, f sessionFile) (path string, ok bool) {
+	if gc.trashDir == "" || gc.isExtraDir(tenant) {
+		return "", false
+	}
+	// In recursive mode, the name includes the shard subdirectory.
+	return filepath.Join(gc.trashDir, gc.trashRun, tenant, f.fi.Name()), true
+}
+
+// Undo restores sessions moved to trash by collections that happened
+// at or after the given time, and returns the number of restored sessions.
+//
+// Restored session files keep their modification times, so to avoid
+// removing them again, fix the collector configuration before calling Undo.
+// Sessions that were created again since they were removed are not restored.
+func (gc *GC) Undo(since time.Time) (int, error) {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	if gc.trashDir == "" {
+		return 0, errors.New("fsgc: trash directory is not set")
+	}
+	runs, err := readDirNames(gc.trashDir)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, run := range runs {
+		t, err := time.Parse(trashTimeFormat, run)
+		if err != nil || t.Before(since) {
+			continue
+		}
+		restored, err := gc.restoreRun(filepath.Join(gc.trashDir, run))
+		n += restored
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// restoreRun restores sessions from the trash subdirectory
+// of a collection and removes it, if it becomes empty.
+func (gc *GC) restoreRun(runDir string) (int, error) {
+	subdirs := []string{""}
+	if gc.tenants {
+		tenants, err := readDirNames(runDir)
+		if err != nil {
+			return 0, err
+		}
+		subdirs = tenants
+	}
+	n := 0
+	for _, sub := range subdirs {
+		restored, err := restoreTree(filepath.Join(runDir, sub), filepath.Join(gc.dir, sub))
+		n += restored
+		if err != nil {
+			return n, err
+		}
+	}
+	os.Remove(runDir) // only if empty
+	return n, nil
+}
+
+// restoreTree moves session files from the src directory tree into the
+// same subdirectories of dst together with their creation time sidecars,
+// skipping files that exist in dst, and removes directories of src that
+// become empty. It returns the number of restored sessions.
+func restoreTree(src, dst string) (int, error) {
+	n := 0
+	var dirs []string
+	err := filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path != src {
+				return nil // sidecar restored with the session
+			}
+			return err
+		}
+		if fi.IsDir() {
+			dirs = append(dirs, path)
+			return nil
+		}
+		if isSidecar(fi.Name()) {
+			return nil // restored with the session
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dst, rel)
+		if _, err := os.Lstat(name); err == nil {
+			return nil // created again
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(path, name); err != nil {
+			return err
+		}
+		os.Rename(path+sidecarExt, name+sidecarExt) // ignore errors
+		n++
+		return nil
+	})
+	// Remove subdirectories before their parents.
+	for i := len(dirs) - 1; i >= 0; i-- {
+		os.Remove(dirs[i]) // only if empty
+	}
+	return n, err
+}
+
+// readDirNames returns names of directory entries.
+func readDirNames(dir string) ([]string, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdirnames(0)
+}
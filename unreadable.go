@@ -0,0 +1,124 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// UnreadablePolicy describes how the collector treats directory entries
+// that it can't examine, for example, because of permission errors.
+type UnreadablePolicy int
+
+const (
+	// AbortUnreadable fails the collection of the directory.
+	// This is the default.
+	AbortUnreadable UnreadablePolicy = iota
+
+	// SkipUnreadable skips the entry and reports the error
+	// in Report.Errors.
+	SkipUnreadable
+
+	// RemoveUnreadable removes the entry if its name matches session
+	// files and the collector has found it unreadable for longer than
+	// the max age, which means that the file is at least that old.
+	// Such entries are removed like other expired sessions, after the
+	// safety checks, and are moved to trash in soft-delete mode. Since
+	// the time is tracked in memory, it starts over when the program
+	// restarts. Other entries are skipped and reported.
+	RemoveUnreadable
+)
+
+// unreadable is a directory entry that couldn't be examined.
+type unreadable struct {
+	name string
+	err  error
+}
+
+// Unreadable sets how the collector treats directory entries it can't
+// examine, and returns the same GC. See UnreadablePolicy.
+//
+// Methods that don't remove anything, such as List, skip such entries,
+// unless the policy is AbortUnreadable. Strict memory mode always uses
+// AbortUnreadable.
+func (gc *GC) Unreadable(p UnreadablePolicy) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.unreadable = p
+	return gc
+}
+
+// handleUnreadable handles the entry of the scanned directory
+// that couldn't be examined.
+func (gc *GC) handleUnreadable(s *dirScan, dir string, u unreadable, now time.Time) {
+	name := filepath.Join(dir, u.name)
+	if gc.unreadable != RemoveUnreadable || !gc.isSession(filepath.Base(name)) {
+		gc.fileErrs = append(gc.fileErrs, gc.redactError(u.err))
+		return
+	}
+	since, ok := gc.unreadableLast[name]
+	if !ok {
+		since = now
+	}
+	if gc.unreadableSince == nil {
+		gc.unreadableSince = make(map[string]time.Time)
+	}
+	gc.unreadableSince[name] = since
+	if now.Sub(since) <= s.policy.MaxAge {
+		gc.fileErrs = append(gc.fileErrs, gc.redactError(u.err))
+		return
+	}
+	f := sessionFile{
+		tenant: s.tenant,
+		path:   name,
+		fi:     unreadableInfo{u.name, since},
+		reason: ReasonAge,
+		lag:    now.Sub(since.Add(s.policy.MaxAge)),
+	}
+	s.counts.Expired++
+	gc.updateLag(f.lag)
+	s.expired = append(s.expired, f)
+}
+
+// startUnreadable starts tracking entries found unreadable by the current
+// collection, forgetting those that are no longer unreadable.
+func (gc *GC) startUnreadable() {
+	gc.unreadableLast, gc.unreadableSince = gc.unreadableSince, nil
+}
+
+// unreadableInfo describes a session file that couldn't be examined.
+// Its modification time is the time when it was found unreadable.
+type unreadableInfo struct {
+	name  string
+	since time.Time
+}
+
+func (fi unreadableInfo) Name() string       { return fi.name }
+func (fi unreadableInfo) Size() int64        { return 0 }
+func (fi unreadableInfo) Mode() os.FileMode  { return 0 }
+func (fi unreadableInfo) ModTime() time.Time { return fi.since }
+func (fi unreadableInfo) IsDir() bool        { return false }
+func (fi unreadableInfo) Sys() interface{}   { return nil }
+
+// checkUnreadable returns the result of checking that the session file
+// that couldn't be examined is unchanged, given the error of examining it
+// again: errChanged if it can now be examined, in which case it is handled
+// by the next collection, the error if the file no longer exists, and nil
+// otherwise.
+func checkUnreadable(err error) error {
+	switch {
+	case err == nil:
+		return errChanged
+	case os.IsNotExist(err):
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// errNotRemoved is reported when a session file still exists after
+// it was successfully removed.
+var errNotRemoved = errors.New("fsgc: session file still exists after removal")
+
+// Verify enables or disables verification of removals and returns the
+// same GC.
+//
+// If enabled, after removing sessions from a directory, the collector
+// lists it again and retries removing session files that still exist.
+// Files that still exist after the retry are counted as failed instead
+// of removed. This guards against failures that are not reported by the
+// system, such as unlink calls silently denied by a sandbox.
+func (gc *GC) Verify(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.verify = enable
+	return gc
+}
+
+// verifyRemoved checks that session files removed from the scanned
+// directory no longer exist, retrying removal of the ones that do.
+func (gc *GC) verifyRemoved(s *dirScan) {
+	removed := gc.removedFiles
+	gc.removedFiles = nil
+	if len(removed) == 0 {
+		return
+	}
+	listed := make(map[string]map[string]bool)
+	exists := func(path string) bool {
+		dir := filepath.Dir(path)
+		names, ok := listed[dir]
+		if !ok {
+			names = make(map[string]bool)
+			list, _ := readDirNames(dir) // missing directory has no files
+			for _, name := range list {
+				names[name] = true
+			}
+			listed[dir] = names
+		}
+		return names[filepath.Base(path)]
+	}
+	for _, f := range removed {
+		if !exists(f.path) {
+			continue
+		}
+		s.counts.Removed--
+		s.counts.Freed -= f.fi.Size()
+		if gc.removedBy[f.reason]--; gc.removedBy[f.reason] == 0 {
+			delete(gc.removedBy, f.reason)
+		}
+		err := gc.discard(s.tenant, f)
+		if err == nil {
+			if _, serr := os.Lstat(f.path); serr == nil {
+				err = &os.PathError{Op: "remove", Path: f.path, Err: errNotRemoved}
+			}
+		}
+		gc.countRemoval(&s.counts, f, err)
+	}
+	gc.removedFiles = nil
+}
@@ -0,0 +1,96 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WarmUp enables or disables the warm-up scan and returns the same GC.
+//
+// If enabled, Start immediately scans session directories without
+// removing anything, so that History, Pressure, and the index (see Index)
+// reflect the state of the store right away, while actual removal waits
+// for the first scheduled collection. The report of the warm-up scan has
+// WarmUp set to true.
+func (gc *GC) WarmUp(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.warmUp = enable
+	return gc
+}
+
+// scanOnly scans session directories without removing anything,
+// records the results, and fills the index.
+func (gc *GC) scanOnly() {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	r := Report{Start: time.Now(), WarmUp: true}
+	gc.nextExpiry = time.Time{}
+	gc.lag = 0
+	gc.startIndex()
+	scans, err := gc.scanOnlyDirs(gc.now())
+	gc.finishIndex(err)
+	if err != nil {
+		if gc.logger != nil {
+			gc.logger.Printf("fsgc: warm-up scan failed: %v", err)
+		}
+		return
+	}
+	for _, s := range scans {
+		r.Scanned += s.counts.Scanned
+		r.Expired += s.counts.Expired
+		r.Pinned += s.counts.Pinned
+		if gc.tenants {
+			if r.Tenants == nil {
+				r.Tenants = make(map[string]Counts)
+			}
+			r.Tenants[s.tenant] = s.counts
+		}
+	}
+	r.Lag = gc.lag
+	r.Duration = time.Since(r.Start)
+	gc.updateCreationRate(&r)
+	gc.updatePressure(r, gc.lag) // nothing was removed
+	gc.record(r)
+}
+
+// scanOnlyDirs scans session directories, including cold storage,
+// and returns the results by tenant.
+func (gc *GC) scanOnlyDirs(now time.Time) ([]*dirScan, error) {
+	dirs, err := gc.sessionDirs()
+	if err != nil {
+		return nil, err
+	}
+	var scans []*dirScan
+	byTenant := make(map[string]*dirScan)
+	for _, d := range dirs {
+		fis, err := gc.readSessions(d.path)
+		if err != nil {
+			if d.cold && os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		s := byTenant[d.tenant]
+		if s == nil {
+			s = &dirScan{tenant: d.tenant, policy: gc.policyFor(d.tenant)}
+			byTenant[d.tenant] = s
+			scans = append(scans, s)
+		}
+		for _, fi := range fis {
+			s.add(sessionFile{path: filepath.Join(d.path, fi.Name()), fi: fi}, gc, now)
+		}
+		// Only counts are needed.
+		s.expired, s.live = s.expired[:0], s.live[:0]
+	}
+	return scans, nil
+}
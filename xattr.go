@@ -0,0 +1,82 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import (
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Names of extended attributes used by the collector.
+const (
+	xattrExpires = "user.fsgc.expires" // expiration time as Unix time in seconds
+	xattrPinned  = "user.fsgc.pinned"  // "1" if the session is pinned
+)
+
+// errXattrUnsupported is returned by functions dealing with extended
+// attributes on systems where they are not supported.
+var errXattrUnsupported = errors.New("fsgc: extended attributes are not supported on this system")
+
+// Xattrs enables or disables reading session metadata from extended
+// attributes of session files, and returns the same GC.
+//
+// When enabled, sessions pinned by calling Pin are never removed,
+// and sessions with expiration time set by SetExpiry expire at that
+// time regardless of max age. The ceiling set by EnforceCeiling still
+// applies to both.
+//
+// Extended attributes are only supported on Linux; on other systems
+// metadata is never found.
+func (gc *GC) Xattrs(enable bool) *GC {
+	gc.mu.Lock()
+	defer gc.mu.Unlock()
+	gc.checkFrozen()
+	gc.xattrs = enable
+	return gc
+}
+
+// SetExpiry sets the expiration time of the session stored in the file
+// with the given path. The time is stored in an extended attribute of the
+// file, so it is kept when the store rewrites the file. Zero time removes
+// the expiration time.
+func SetExpiry(path string, t time.Time) error {
+	if t.IsZero() {
+		return removeXattr(path, xattrExpires)
+	}
+	return setXattr(path, xattrExpires, []byte(strconv.FormatInt(t.Unix(), 10)))
+}
+
+// Pin pins or unpins the session stored in the file with the given path.
+// The flag is stored in an extended attribute of the file.
+func Pin(path string, pinned bool) error {
+	if !pinned {
+		return removeXattr(path, xattrPinned)
+	}
+	return setXattr(path, xattrPinned, []byte("1"))
+}
+
+// xattrMeta is session metadata stored in extended attributes.
+type xattrMeta struct {
+	expires time.Time
+	pinned  bool
+}
+
+// readXattrMeta reads metadata from extended attributes of the file.
+// Missing or invalid attributes are ignored.
+func readXattrMeta(path string) (m xattrMeta) {
+	if b, err := getXattr(path, xattrPinned); err == nil && string(b) == "1" {
+		m.pinned = true
+	}
+	if b, err := getXattr(path, xattrExpires); err == nil {
+		if secs, err := strconv.ParseInt(string(b), 10, 64); err == nil {
+			m.expires = time.Unix(secs, 0)
+		}
+	}
+	return m
+}
@@ -0,0 +1,31 @@
+// Written in 2015 by Dmitry Chestnykh.
+//
+// To the extent possible under law, the author have dedicated all copyright
+// and related and neighboring rights to this software to the public domain
+// worldwide. This software is distributed without any warranty.
+// http://creativecommons.org/publicdomain/zero/1.0/
+
+package fsgc
+
+import "syscall"
+
+func getXattr(path, name string) ([]byte, error) {
+	buf := make([]byte, 64)
+	n, err := syscall.Getxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func setXattr(path, name string, value []byte) error {
+	return syscall.Setxattr(path, name, value, 0)
+}
+
+func removeXattr(path, name string) error {
+	err := syscall.Removexattr(path, name)
+	if err == syscall.ENODATA {
+		return nil // not set
+	}
+	return err
+}